@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/caarlos0/env/v6"
+	"github.com/pkg/errors"
+	"github.com/tamarakaufler/watcher-daemon/internal/daemon"
+)
+
+const ctlUsage = `Usage:
+  watcher-daemon ctl [flags] status    show status of the running daemon
+  watcher-daemon ctl [flags] trigger   run the command without a detected change
+  watcher-daemon ctl [flags] reload    make the current state of the files the new baseline
+  watcher-daemon ctl [flags] pause     stop checking for changes
+  watcher-daemon ctl [flags] resume    continue checking for changes
+  watcher-daemon ctl [flags] tail      follow runs and their output
+
+The exit status is 1 when the last run failed.
+
+Flags:
+`
+
+// errLastRunFailed makes ctl exit with a non-zero status without an error message.
+var errLastRunFailed = errors.New("last run failed")
+
+// ctl controls a running daemon through its API.
+func ctl(args []string, out io.Writer) error {
+	// only the API address is read, so that other settings, eg of the watch,
+	// cannot break the client
+	var cfg struct {
+		APIAddr string `env:"WATCHER_DAEMON_API_ADDR"`
+	}
+	if err := env.Parse(&cfg); err != nil {
+		return errors.Wrap(err, "cannot read the API address")
+	}
+
+	fs := flag.NewFlagSet("ctl", flag.ContinueOnError)
+	addr := fs.String("addr", cfg.APIAddr, "API address of the daemon, host:port or unix:<socket path>")
+	asJSON := fs.Bool("json", false, "print JSON output")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), ctlUsage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("exactly one ctl command is required")
+	}
+
+	ctx := context.Background()
+	client := daemon.NewClient(*addr)
+
+	var status daemon.Status
+	var err error
+	switch cmd := fs.Arg(0); cmd {
+	case "status":
+		status, err = client.Status(ctx)
+	case "trigger", "reload", "pause", "resume":
+		status, err = client.Control(ctx, cmd)
+	case "tail":
+		return tail(ctx, client, out, *asJSON)
+	default:
+		fs.Usage()
+		return errors.Errorf("unknown ctl command %s", cmd)
+	}
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		if err := printJSON(out, status); err != nil {
+			return err
+		}
+	} else {
+		printStatus(out, status)
+	}
+
+	if status.LastRun != nil && !status.LastRun.Succeeded() {
+		return errLastRunFailed
+	}
+	return nil
+}
+
+// tail prints runs and their output as they happen, until the daemon
+// cannot be reached.
+func tail(ctx context.Context, client *daemon.Client, out io.Writer, asJSON bool) error {
+	return client.Events(ctx, func(ev daemon.Event) error {
+		if asJSON {
+			switch ev.Type {
+			case daemon.EventRunStarted, daemon.EventRunOutput, daemon.EventRunFinished:
+				return json.NewEncoder(out).Encode(ev)
+			}
+			return nil
+		}
+
+		switch ev.Type {
+		case daemon.EventRunStarted:
+			fmt.Fprintf(out, "--- run %d started at %s\n", ev.RunID, ev.Time.Format(time.RFC3339))
+		case daemon.EventRunOutput:
+			fmt.Fprintln(out, ev.Line)
+		case daemon.EventRunFinished:
+			fmt.Fprintf(out, "--- run %d %s\n", ev.RunID, runOutcome(*ev.Run))
+		}
+		return nil
+	})
+}
+
+func printStatus(out io.Writer, s daemon.Status) {
+	fmt.Fprintf(out, "Watching:   %s (%s)\n", s.BasePath, s.Extension)
+	if s.Excluded != "" {
+		fmt.Fprintf(out, "Excluded:   %s\n", s.Excluded)
+	}
+	fmt.Fprintf(out, "Command:    %s\n", s.Command)
+	fmt.Fprintf(out, "Files:      %d\n", s.Files)
+	fmt.Fprintf(out, "Paused:     %t\n", s.Paused)
+	if !s.LastScan.IsZero() {
+		fmt.Fprintf(out, "Last scan:  %s (took %s)\n",
+			s.LastScan.Format(time.RFC3339), s.LastScanDuration.Round(time.Microsecond))
+	}
+	if s.LastRun != nil {
+		fmt.Fprintf(out, "Last run:   %d %s\n", s.LastRun.ID, runOutcome(*s.LastRun))
+	}
+}
+
+func runOutcome(r daemon.Run) string {
+	outcome := "succeeded"
+	if !r.Succeeded() {
+		outcome = fmt.Sprintf("failed (exit %d)", r.ExitCode)
+	}
+	if r.Service != "" {
+		outcome += fmt.Sprintf(", service %s,", r.Service)
+	}
+	return fmt.Sprintf("%s at %s in %s", outcome, r.Start.Format(time.RFC3339), r.Duration.Round(time.Millisecond))
+}
+
+func printJSON(out io.Writer, v interface{}) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
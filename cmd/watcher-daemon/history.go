@@ -0,0 +1,134 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tamarakaufler/watcher-daemon/internal/daemon"
+)
+
+const historyUsage = `Usage:
+  watcher-daemon history [flags]     list recorded runs
+  watcher-daemon history show <id>   print details and captured output of a run
+
+Flags:
+`
+
+// history lists or shows runs recorded in the run history of the state directory.
+func history(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	failed := fs.Bool("failed", false, "list only failed runs")
+	file := fs.String("file", "", "list only runs triggered by files containing this string")
+	since := fs.Duration("since", 0, "list only runs started within this duration, eg 1h")
+	limit := fs.Int("n", 20, "maximum number of most recent runs to list (0 lists all)")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), historyUsage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// only the state directory is read, so that other settings, eg of the
+	// watch, cannot break the command
+	stateDir, err := daemon.StateDirFromEnvironment(nil)
+	if err != nil {
+		return err
+	}
+	runs, err := daemon.ReadHistory(stateDir)
+	if err != nil {
+		return err
+	}
+
+	if fs.Arg(0) == "show" {
+		if fs.NArg() != 2 {
+			return errors.New("run ID is required, eg: watcher-daemon history show 12")
+		}
+		id, err := strconv.Atoi(fs.Arg(1))
+		if err != nil {
+			return errors.Wrapf(err, "invalid run ID %s", fs.Arg(1))
+		}
+		for _, r := range runs {
+			if r.ID == id {
+				showRun(out, r)
+				return nil
+			}
+		}
+		return errors.Errorf("run %d not found in the history", id)
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return errors.Errorf("unknown arguments %v", fs.Args())
+	}
+
+	var filtered []daemon.Run
+	for _, r := range runs {
+		if *failed && r.Succeeded() {
+			continue
+		}
+		if *since != 0 && r.Start.Before(time.Now().Add(-*since)) {
+			continue
+		}
+		if *file != "" && !triggeredBy(r, *file) {
+			continue
+		}
+		filtered = append(filtered, r)
+	}
+	if *limit > 0 && len(filtered) > *limit {
+		filtered = filtered[len(filtered)-*limit:]
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTARTED\tDURATION\tEXIT\tFILES\tCOMMAND")
+	for _, r := range filtered {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%s\t%s\n",
+			r.ID, r.Start.Format("2006-01-02 15:04:05"), r.Duration.Round(time.Millisecond),
+			r.ExitCode, summariseFiles(r.Files), r.Command)
+	}
+	return w.Flush()
+}
+
+func triggeredBy(r daemon.Run, file string) bool {
+	for _, f := range r.Files {
+		if strings.Contains(f, file) {
+			return true
+		}
+	}
+	return false
+}
+
+func summariseFiles(files []string) string {
+	switch len(files) {
+	case 0:
+		return "-"
+	case 1:
+		return files[0]
+	}
+	return fmt.Sprintf("%s (+%d)", files[0], len(files)-1)
+}
+
+func showRun(out io.Writer, r daemon.Run) {
+	fmt.Fprintf(out, "Run:      %d\n", r.ID)
+	fmt.Fprintf(out, "Started:  %s\n", r.Start.Format(time.RFC3339))
+	fmt.Fprintf(out, "Duration: %s\n", r.Duration.Round(time.Millisecond))
+	fmt.Fprintf(out, "Command:  %s\n", r.Command)
+	fmt.Fprintf(out, "Exit:     %d\n", r.ExitCode)
+	if r.Error != "" {
+		fmt.Fprintf(out, "Error:    %s\n", r.Error)
+	}
+	fmt.Fprintln(out, "Files:")
+	for _, f := range r.Files {
+		fmt.Fprintf(out, "  %s\n", f)
+	}
+	if r.Summary != nil {
+		fmt.Fprintf(out, "Summary:  %s\n", r.Summary)
+	}
+	fmt.Fprintln(out, "Output:")
+	fmt.Fprint(out, r.Output)
+}
@@ -5,29 +5,49 @@ import (
 	"log"
 	"os"
 	"os/signal"
-	"strconv"
 	"syscall"
-	"time"
 
-	"github.com/tamarakaufler/watcher-daemon/internal/daemon"
+	"github.com/tamarakaufler/watcher-daemon/watcher"
 )
 
 func main() {
-
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
-
-	d, err := daemon.New()
-	if err != nil {
-		log.Panic(err)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "history":
+			if err := history(os.Args[2:], os.Stdout); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "ctl":
+			if err := ctl(os.Args[2:], os.Stdout); err != nil {
+				if err != errLastRunFailed {
+					log.Print(err)
+				}
+				os.Exit(1)
+			}
+			return
+		}
 	}
 
-	fr, err := strconv.Atoi(d.Frequency)
+	w, err := watcher.NewFromEnv()
 	if err != nil {
 		log.Panic(err)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(fr)*time.Second)
+	// the context lives as long as the daemon, it is used by the API server
+	// and by commands run on a change
+	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	d.Watch(ctx, sigCh)
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		sig := <-sigCh
+		log.Printf("received %s, stopping", sig)
+		cancel()
+	}()
+
+	if err := w.Run(ctx); err != nil && err != context.Canceled {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,303 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const unixPrefix = "unix:"
+
+// Status describes the configuration and the current state of the daemon.
+type Status struct {
+	BasePath         string        `json:"base_path"`
+	Extension        string        `json:"extension"`
+	Excluded         string        `json:"excluded"`
+	Frequency        string        `json:"frequency"`
+	Command          string        `json:"command"`
+	GoMode           bool          `json:"go_mode"`
+	Paused           bool          `json:"paused"`
+	Files            int           `json:"files"`
+	LastScan         time.Time     `json:"last_scan"`
+	LastScanDuration time.Duration `json:"last_scan_duration"`
+	LastRun          *Run          `json:"last_run,omitempty"`
+}
+
+// Status provides the configuration and the current state of the daemon.
+func (d *Daemon) Status() Status {
+	s := Status{
+		BasePath:  d.BasePath,
+		Extension: d.Extention,
+		Excluded:  d.Excluded,
+		Frequency: d.frequency.String(),
+		Command:   d.Command,
+		GoMode:    d.GoMode,
+	}
+
+	d.stateMux.Lock()
+	s.Paused = d.paused
+	s.LastScan = d.lastScan
+	s.LastScanDuration = d.lastScanDuration
+	d.stateMux.Unlock()
+
+	d.snapshotMux.Lock()
+	s.Files = len(d.snapshot)
+	d.snapshotMux.Unlock()
+
+	if runs := d.Runs(); len(runs) != 0 {
+		s.LastRun = &runs[len(runs)-1]
+	}
+	return s
+}
+
+// Files provides the watched files found by the latest scan, sorted by path.
+func (d *Daemon) Files() []FileInfo {
+	d.snapshotMux.Lock()
+	defer d.snapshotMux.Unlock()
+
+	files := make([]FileInfo, 0, len(d.snapshot))
+	for _, f := range d.snapshot {
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	return files
+}
+
+// Paused reports whether the watch is paused.
+func (d *Daemon) Paused() bool {
+	d.stateMux.Lock()
+	defer d.stateMux.Unlock()
+
+	return d.paused
+}
+
+// Pause stops checking for changes until the watch is resumed. Changes made
+// in the meantime are detected after resuming.
+func (d *Daemon) Pause() {
+	d.stateMux.Lock()
+	defer d.stateMux.Unlock()
+
+	d.paused = true
+}
+
+// Resume continues checking for changes.
+func (d *Daemon) Resume() {
+	d.stateMux.Lock()
+	defer d.stateMux.Unlock()
+
+	d.paused = false
+}
+
+// Trigger requests a run of the command without a detected change.
+// Requests made while a previous one is still pending are ignored.
+func (d *Daemon) Trigger() {
+	select {
+	case d.triggerCh <- struct{}{}:
+	default:
+	}
+}
+
+// Reload takes a new snapshot of the watched files and makes it the baseline
+// for detecting changes, without running the command for the differences.
+func (d *Daemon) Reload(ctx context.Context) error {
+	files, err := d.collectFiles(ctx)
+	if err != nil {
+		return err
+	}
+
+	if d.SemanticFilter {
+		d.resetGoCode(ctx, files)
+	}
+
+	d.snapshotMux.Lock()
+	defer d.snapshotMux.Unlock()
+
+	d.snapshot = NewSnapshot(files)
+	return d.saveSnapshot(d.snapshot)
+}
+
+// APIHandler provides the handler of the status and control API.
+func (d *Daemon) APIHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/status", get(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, d.Status())
+	}))
+	mux.HandleFunc("/files", get(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, d.Files())
+	}))
+	mux.HandleFunc("/runs", get(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, d.Runs())
+	}))
+	mux.Handle("/metrics", d.MetricsHandler())
+	mux.Handle("/events", d.EventsHandler())
+	mux.HandleFunc("/trigger", post(func(w http.ResponseWriter, r *http.Request) {
+		d.Trigger()
+		writeJSON(w, http.StatusAccepted, d.Status())
+	}))
+	mux.HandleFunc("/reload", post(func(w http.ResponseWriter, r *http.Request) {
+		if err := d.Reload(r.Context()); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, d.Status())
+	}))
+	mux.HandleFunc("/pause", post(func(w http.ResponseWriter, r *http.Request) {
+		d.Pause()
+		writeJSON(w, http.StatusOK, d.Status())
+	}))
+	mux.HandleFunc("/resume", post(func(w http.ResponseWriter, r *http.Request) {
+		d.Resume()
+		writeJSON(w, http.StatusOK, d.Status())
+	}))
+
+	return localOnly(mux)
+}
+
+// localOnly rejects the requests addressed to other hosts than localhost,
+// eg through DNS rebinding, and the cross-origin requests of web pages.
+func localOnly(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isLoopbackHost(hostname(r.Host)) {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "host not allowed"})
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" {
+			u, err := url.Parse(origin)
+			if err != nil || u.Scheme != "http" || u.Host != r.Host {
+				writeJSON(w, http.StatusForbidden, map[string]string{"error": "cross-origin request not allowed"})
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// validateAPIAddr checks that the API is served on a unix socket
+// or on a loopback address, so that it is not reachable from the network.
+func validateAPIAddr(addr string) error {
+	network, address := ParseAPIAddr(addr)
+	if network == "unix" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return errors.Wrapf(err, "invalid API address %s", addr)
+	}
+	if !isLoopbackHost(host) {
+		return errors.Errorf("the API address %s is not local, use localhost, a loopback address or a unix socket", addr)
+	}
+	return nil
+}
+
+// hostname provides the host of the Host header without the port.
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+}
+
+// isLoopbackHost reports whether the host is localhost or a loopback address.
+func isLoopbackHost(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// ServeAPI serves the status and control API on the configured address
+// until the context is done.
+func (d *Daemon) ServeAPI(ctx context.Context) error {
+	network, address := ParseAPIAddr(d.APIAddr)
+	if network == "unix" {
+		if err := removeStaleSocket(address); err != nil {
+			return err
+		}
+	}
+
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return errors.Wrapf(err, "cannot listen on %s", d.APIAddr)
+	}
+	if network == "unix" {
+		if err := os.Chmod(address, 0600); err != nil {
+			l.Close()
+			return errors.Wrapf(err, "cannot restrict access to socket %s", address)
+		}
+	}
+
+	srv := &http.Server{Handler: d.APIHandler()}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	d.logger.Infof("serving the API on %s", d.APIAddr)
+	if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// removeStaleSocket removes a socket left behind by a previous run, which
+// would prevent listening. Any other file at the path is kept.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "cannot check the socket %s", path)
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return errors.Errorf("cannot serve the API on %s, the file exists and is not a socket", path)
+	}
+	return errors.Wrapf(os.Remove(path), "cannot remove stale socket %s", path)
+}
+
+// ParseAPIAddr splits the API address into the network and the address
+// for net.Listen and net.Dial.
+func ParseAPIAddr(addr string) (string, string) {
+	if strings.HasPrefix(addr, unixPrefix) {
+		return "unix", strings.TrimPrefix(addr, unixPrefix)
+	}
+	return "tcp", addr
+}
+
+func get(h http.HandlerFunc) http.HandlerFunc {
+	return allowMethod(http.MethodGet, h)
+}
+
+func post(h http.HandlerFunc) http.HandlerFunc {
+	return allowMethod(http.MethodPost, h)
+}
+
+func allowMethod(method string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		h(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	// the status has already been sent, so an encoding error cannot be reported
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,223 @@
+// +build unit_tests
+
+package daemon_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tamarakaufler/watcher-daemon/internal/daemon"
+)
+
+func TestDaemon_APIHandler(t *testing.T) {
+	d, err := daemon.NewWithEnvironment(map[string]string{
+		"WATCHER_DAEMON_BASE_PATH": "fixtures/basepath",
+		"WATCHER_DAEMON_EXTENSION": ".go",
+		"WATCHER_DAEMON_COMMAND":   "echo {{.Files}}",
+		"WATCHER_DAEMON_FREQUENCY": "5",
+		"WATCHER_DAEMON_STATE_DIR": t.TempDir(),
+	})
+	require.Nil(t, err, "daemon creation failure")
+
+	ctx := context.Background()
+	_, _, err = d.Scan(ctx)
+	require.Nil(t, err)
+	d.RunCommand(ctx, daemon.ChangeSet{{Path: "fixtures/basepath/test.go", Name: "test.go", Op: daemon.OpModified}})
+
+	srv := httptest.NewServer(d.APIHandler())
+	defer srv.Close()
+
+	do := func(method, path string, wantCode int, v interface{}) {
+		req, err := http.NewRequest(method, srv.URL+path, nil)
+		require.Nil(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.Nil(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, wantCode, resp.StatusCode, "%s %s", method, path)
+		if v != nil {
+			require.Nil(t, json.NewDecoder(resp.Body).Decode(v))
+		}
+	}
+
+	var status daemon.Status
+	do(http.MethodGet, "/status", http.StatusOK, &status)
+	require.Equal(t, "fixtures/basepath", status.BasePath)
+	require.Equal(t, "5s", status.Frequency)
+	require.Equal(t, 5, status.Files)
+	require.False(t, status.Paused)
+	require.False(t, status.LastScan.IsZero())
+	require.NotNil(t, status.LastRun)
+	require.Equal(t, "echo fixtures/basepath/test.go", status.LastRun.Command)
+
+	var files []daemon.FileInfo
+	do(http.MethodGet, "/files", http.StatusOK, &files)
+	require.Len(t, files, 5)
+	require.Equal(t, "fixtures/basepath/subdir1/test.go", files[0].Path)
+
+	var runs []daemon.Run
+	do(http.MethodGet, "/runs", http.StatusOK, &runs)
+	require.Len(t, runs, 1)
+
+	do(http.MethodPost, "/pause", http.StatusOK, &status)
+	require.True(t, status.Paused)
+	require.True(t, d.Paused())
+
+	do(http.MethodPost, "/resume", http.StatusOK, &status)
+	require.False(t, status.Paused)
+
+	do(http.MethodPost, "/trigger", http.StatusAccepted, nil)
+
+	do(http.MethodPost, "/reload", http.StatusOK, &status)
+	require.Equal(t, 5, status.Files)
+
+	do(http.MethodPost, "/status", http.StatusMethodNotAllowed, nil)
+	do(http.MethodGet, "/pause", http.StatusMethodNotAllowed, nil)
+}
+
+func TestNewWithEnvironment_APIAddr(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		addr    string
+		wantErr bool
+	}{
+		{addr: "localhost:8080"},
+		{addr: "127.0.0.1:8080"},
+		{addr: "[::1]:8080"},
+		{addr: "unix:/tmp/watcher.sock"},
+		{addr: ":8080", wantErr: true},
+		{addr: "0.0.0.0:8080", wantErr: true},
+		{addr: "192.168.1.10:8080", wantErr: true},
+		{addr: "example.com:8080", wantErr: true},
+		{addr: "localhost", wantErr: true},
+	}
+	for _, tt := range tests {
+		_, err := daemon.NewWithEnvironment(map[string]string{
+			"WATCHER_DAEMON_API_ADDR": tt.addr,
+		}, daemon.WithStateDir(""))
+		if tt.wantErr {
+			require.NotNil(t, err, tt.addr)
+		} else {
+			require.Nil(t, err, tt.addr)
+		}
+	}
+}
+
+func TestDaemon_APIHandler_LocalOnly(t *testing.T) {
+	t.Parallel()
+
+	d, err := daemon.NewWithEnvironment(map[string]string{},
+		daemon.WithStateDir(""),
+		daemon.WithBasePath("fixtures/basepath"),
+	)
+	require.Nil(t, err, "daemon creation failure")
+	handler := d.APIHandler()
+
+	tests := []struct {
+		name     string
+		method   string
+		host     string
+		origin   string
+		wantCode int
+	}{
+		{name: "localhost", method: http.MethodGet, host: "localhost:8080", wantCode: http.StatusOK},
+		{name: "loopback", method: http.MethodGet, host: "127.0.0.1:8080", wantCode: http.StatusOK},
+		{name: "loopback IPv6", method: http.MethodGet, host: "[::1]:8080", wantCode: http.StatusOK},
+		{name: "unix socket", method: http.MethodGet, host: "localhost", wantCode: http.StatusOK},
+		{name: "rebound host", method: http.MethodGet, host: "attacker.example.com", wantCode: http.StatusForbidden},
+		{
+			name: "same origin", method: http.MethodPost, host: "localhost:8080",
+			origin: "http://localhost:8080", wantCode: http.StatusOK,
+		},
+		{
+			name: "cross origin", method: http.MethodPost, host: "localhost:8080",
+			origin: "http://attacker.example.com", wantCode: http.StatusForbidden,
+		},
+		{
+			name: "other local port", method: http.MethodPost, host: "localhost:8080",
+			origin: "http://localhost:3000", wantCode: http.StatusForbidden,
+		},
+		{
+			name: "opaque origin", method: http.MethodPost, host: "localhost:8080",
+			origin: "null", wantCode: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		path := "/status"
+		if tt.method == http.MethodPost {
+			path = "/pause"
+		}
+		req := httptest.NewRequest(tt.method, path, nil)
+		req.Host = tt.host
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, tt.wantCode, rec.Code, tt.name)
+	}
+	require.True(t, d.Paused(), "the same origin request is served")
+}
+
+func TestDaemon_Status_Interval(t *testing.T) {
+	t.Parallel()
+
+	d, err := daemon.NewWithEnvironment(map[string]string{
+		"WATCHER_DAEMON_FREQUENCY": "5",
+	}, daemon.WithStateDir(""), daemon.WithInterval(250*time.Millisecond))
+	require.Nil(t, err, "daemon creation failure")
+	require.Equal(t, "250ms", d.Status().Frequency)
+}
+
+func TestDaemon_ServeAPI_ExistingFile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	require.Nil(t, ioutil.WriteFile(path, []byte("keep me"), 0644))
+
+	d, err := daemon.NewWithEnvironment(map[string]string{
+		"WATCHER_DAEMON_API_ADDR": "unix:" + path,
+	}, daemon.WithStateDir(""))
+	require.Nil(t, err, "daemon creation failure")
+
+	require.NotNil(t, d.ServeAPI(context.Background()))
+	data, err := ioutil.ReadFile(path)
+	require.Nil(t, err)
+	require.Equal(t, "keep me", string(data))
+}
+
+func TestDaemon_ServeAPI_StaleSocket(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "api.sock")
+	l, err := net.Listen("unix", path)
+	require.Nil(t, err)
+	// the socket is left behind, as after a crash
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	require.Nil(t, l.Close())
+
+	d, err := daemon.NewWithEnvironment(map[string]string{
+		"WATCHER_DAEMON_API_ADDR": "unix:" + path,
+	}, daemon.WithStateDir(""))
+	require.Nil(t, err, "daemon creation failure")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- d.ServeAPI(ctx)
+	}()
+	require.Eventually(t, func() bool {
+		_, err := daemon.NewClient("unix:" + path).Status(ctx)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond, "API not served")
+	cancel()
+	require.Nil(t, <-done)
+}
@@ -0,0 +1,85 @@
+package daemon
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// Backend provides the files of the watched tree for each scan, eg from
+// a version control system or a remote tree. The files are filtered by
+// the include and exclude patterns. By default, the base path is walked.
+type Backend interface {
+	Files(ctx context.Context) ([]FileInfo, error)
+}
+
+// collectFiles provides the watched files from the backend.
+func (d *Daemon) collectFiles(ctx context.Context) ([]FileInfo, error) {
+	if d.backend == nil {
+		return d.CollectFiles(ctx)
+	}
+
+	files, err := d.backend.Files(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "error collecting files from the backend")
+	}
+
+	var watched []FileInfo
+	for _, f := range files {
+		ok, err := d.isWatchedFile(ctx, Root{}, f.Path, f.Name)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			watched = append(watched, f)
+		}
+	}
+	return watched, nil
+}
+
+// isWatchedFile reports whether the file matches the watch criteria
+// of the root and is not excluded.
+func (d *Daemon) isWatchedFile(ctx context.Context, r Root, path, name string) (bool, error) {
+	if len(r.Include) != 0 {
+		if !matchesName(r.Include, path) {
+			return false, nil
+		}
+	} else if !d.isWatched(path) {
+		return false, nil
+	}
+	if len(d.excluded) == 0 && len(r.Exclude) == 0 {
+		return true, nil
+	}
+
+	isExcl, err := d.isExcludedFromRoot(ctx, r, path, name)
+	if err != nil {
+		return false, errors.Wrap(err, "cannot proccess exclusion of files")
+	}
+	return !isExcl, nil
+}
+
+// isIncluded reports whether the file name matches any include pattern.
+func (d *Daemon) isIncluded(path string) bool {
+	return matchesName(d.include, path)
+}
+
+// validateInclude checks the syntax of the include patterns.
+func validateInclude(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return errors.Wrapf(err, "invalid include pattern %q", pattern)
+		}
+	}
+	return nil
+}
+
+// matchesName reports whether the file name matches any of the patterns.
+func matchesName(patterns []string, path string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, filepath.Base(path)); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,32 @@
+package daemon
+
+// Op describes the kind of change detected for a file.
+type Op string
+
+const (
+	// OpCreated is used for files, which appeared since the previous check.
+	OpCreated Op = "created"
+	// OpModified is used for files modified since the previous check.
+	OpModified Op = "modified"
+	// OpRemoved is used for files, which disappeared since the previous check.
+	OpRemoved Op = "removed"
+)
+
+// Change captures a single detected file change.
+type Change struct {
+	Path string
+	Name string
+	Op   Op
+}
+
+// ChangeSet holds all changes detected during one watch run.
+type ChangeSet []Change
+
+// Paths returns paths of all changed files.
+func (cs ChangeSet) Paths() []string {
+	paths := make([]string, 0, len(cs))
+	for _, c := range cs {
+		paths = append(paths, c.Path)
+	}
+	return paths
+}
@@ -0,0 +1,152 @@
+package daemon
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Client talks to the status and control API of a running daemon.
+type Client struct {
+	addr       string
+	baseURL    string
+	httpClient *http.Client
+}
+
+// NewClient provides a client of the API served on the address,
+// either host:port or a unix socket path prefixed with unix:.
+func NewClient(addr string) *Client {
+	network, address := ParseAPIAddr(addr)
+	dialer := &net.Dialer{}
+
+	baseURL := "http://" + address
+	if network == "unix" {
+		// the host is not used when connecting through a unix socket,
+		// but the API accepts only the local ones
+		baseURL = "http://localhost"
+	}
+
+	return &Client{
+		addr:    addr,
+		baseURL: baseURL,
+		httpClient: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return dialer.DialContext(ctx, network, address)
+				},
+			},
+		},
+	}
+}
+
+// Status provides the status of the daemon.
+func (c *Client) Status(ctx context.Context) (Status, error) {
+	var s Status
+	err := c.do(ctx, http.MethodGet, "/status", &s)
+	return s, err
+}
+
+// Files provides the watched files.
+func (c *Client) Files(ctx context.Context) ([]FileInfo, error) {
+	var files []FileInfo
+	err := c.do(ctx, http.MethodGet, "/files", &files)
+	return files, err
+}
+
+// Runs provides the recent runs.
+func (c *Client) Runs(ctx context.Context) ([]Run, error) {
+	var runs []Run
+	err := c.do(ctx, http.MethodGet, "/runs", &runs)
+	return runs, err
+}
+
+// Control sends one of the control actions (trigger, reload, pause, resume)
+// and provides the resulting status.
+func (c *Client) Control(ctx context.Context, action string) (Status, error) {
+	var s Status
+	err := c.do(ctx, http.MethodPost, "/"+action, &s)
+	return s, err
+}
+
+// Events streams events of the daemon to the handler until the context
+// is done, the connection is closed or the handler returns an error.
+func (c *Client) Events(ctx context.Context, handle func(Event) error) error {
+	if c.addr == "" {
+		return errors.New("API address is not configured")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/events", nil)
+	if err != nil {
+		return errors.Wrap(err, "cannot create API request")
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "cannot reach the daemon")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("GET /events: %s", resp.Status)
+	}
+
+	// the lines are read whole, as a run_finished event with many files
+	// or a long output makes a line of any length
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if ctx.Err() != nil || err == io.EOF {
+				return nil
+			}
+			return errors.Wrap(err, "event stream interrupted")
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var ev Event
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev); err != nil {
+			return errors.Wrap(err, "cannot decode event")
+		}
+		if err := handle(ev); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *Client) do(ctx context.Context, method, path string, v interface{}) error {
+	if c.addr == "" {
+		return errors.New("API address is not configured")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, nil)
+	if err != nil {
+		return errors.Wrap(err, "cannot create API request")
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "cannot reach the daemon")
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrap(err, "cannot read API response")
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Error != "" {
+			return errors.Errorf("%s %s: %s", method, path, apiErr.Error)
+		}
+		return errors.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	return errors.Wrap(json.Unmarshal(body, v), "cannot decode API response")
+}
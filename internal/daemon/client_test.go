@@ -0,0 +1,128 @@
+// +build unit_tests
+
+package daemon_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tamarakaufler/watcher-daemon/internal/daemon"
+)
+
+func TestClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wd")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	addr := "unix:" + filepath.Join(dir, "api.sock")
+
+	os.Setenv("WATCHER_DAEMON_BASE_PATH", "fixtures/basepath")
+	os.Setenv("WATCHER_DAEMON_EXTENSION", ".go")
+	os.Setenv("WATCHER_DAEMON_EXCLUDED", "")
+	os.Setenv("WATCHER_DAEMON_COMMAND", "false")
+	os.Setenv("WATCHER_DAEMON_STATE_DIR", dir)
+	os.Setenv("WATCHER_DAEMON_API_ADDR", addr)
+	defer os.Unsetenv("WATCHER_DAEMON_COMMAND")
+	defer os.Unsetenv("WATCHER_DAEMON_STATE_DIR")
+	defer os.Unsetenv("WATCHER_DAEMON_API_ADDR")
+
+	d, err := daemon.New()
+	require.Nil(t, err, "daemon creation failure")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, _, err = d.Scan(ctx)
+	require.Nil(t, err)
+	d.RunCommand(ctx, nil)
+
+	go func() {
+		require.Nil(t, d.ServeAPI(ctx))
+	}()
+
+	client := daemon.NewClient(addr)
+	var status daemon.Status
+	require.Eventually(t, func() bool {
+		status, err = client.Status(ctx)
+		return err == nil
+	}, time.Second, 10*time.Millisecond, "API not served")
+
+	require.Equal(t, 5, status.Files)
+	require.NotNil(t, status.LastRun)
+	require.False(t, status.LastRun.Succeeded())
+
+	files, err := client.Files(ctx)
+	require.Nil(t, err)
+	require.Len(t, files, 5)
+
+	runs, err := client.Runs(ctx)
+	require.Nil(t, err)
+	require.Len(t, runs, 1)
+
+	status, err = client.Control(ctx, "pause")
+	require.Nil(t, err)
+	require.True(t, status.Paused)
+
+	_, err = client.Control(ctx, "unknown")
+	require.NotNil(t, err)
+
+	_, err = daemon.NewClient("").Status(ctx)
+	require.EqualError(t, err, "API address is not configured")
+}
+
+func TestClient_EventsLargeRun(t *testing.T) {
+	t.Parallel()
+
+	d, err := daemon.NewWithEnvironment(map[string]string{
+		"WATCHER_DAEMON_COMMAND": "true",
+	}, daemon.WithStateDir(""))
+	require.Nil(t, err, "daemon creation failure")
+	srv := httptest.NewServer(d.APIHandler())
+	defer srv.Close()
+
+	// the run_finished event is longer than any line buffer
+	var changes daemon.ChangeSet
+	for i := 0; i < 3000; i++ {
+		name := fmt.Sprintf("file%04d.go", i)
+		changes = append(changes, daemon.Change{
+			Path: "fixtures/basepath/generated/" + name, Name: name, Op: daemon.OpModified,
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	finished := make(chan daemon.Run, 1)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- daemon.NewClient(strings.TrimPrefix(srv.URL, "http://")).Events(ctx, func(ev daemon.Event) error {
+			if ev.Type == daemon.EventRunFinished {
+				select {
+				case finished <- *ev.Run:
+				default:
+				}
+				cancel()
+			}
+			return nil
+		})
+	}()
+
+	// the client may not be subscribed yet
+	var run daemon.Run
+	require.Eventually(t, func() bool {
+		d.RunCommand(context.Background(), changes)
+		select {
+		case run = <-finished:
+			return true
+		case <-time.After(50 * time.Millisecond):
+			return false
+		}
+	}, 5*time.Second, time.Millisecond, "run_finished event not received")
+	require.Len(t, run.Files, 3000)
+	require.Nil(t, <-errCh)
+}
@@ -0,0 +1,170 @@
+package daemon
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock provides the time to the daemon. The system clock is used unless
+// another clock, eg a FakeClock in tests, is provided with WithClock.
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	After(d time.Duration) <-chan time.Time
+	Sleep(d time.Duration)
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker delivers ticks of a Clock at intervals.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// systemClock is the Clock of the time package.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) Since(t time.Time) time.Duration        { return time.Since(t) }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (systemClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+
+func (systemClock) NewTicker(d time.Duration) Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+type systemTicker struct {
+	*time.Ticker
+}
+
+func (t systemTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+// FakeClock is a Clock, whose time only moves when advanced. Timers and
+// tickers fire when the time is advanced past their deadline.
+type FakeClock struct {
+	mux    sync.Mutex
+	added  *sync.Cond
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock *FakeClock
+	at    time.Time
+	// period is set for tickers
+	period time.Duration
+	ch     chan time.Time
+}
+
+// NewFakeClock creates a fake clock set to the time.
+func NewFakeClock(now time.Time) *FakeClock {
+	c := &FakeClock{now: now}
+	c.added = sync.NewCond(&c.mux)
+	return c
+}
+
+// Now provides the current fake time.
+func (c *FakeClock) Now() time.Time {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.now
+}
+
+// Since provides the fake time elapsed since t.
+func (c *FakeClock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+// After provides a channel receiving the fake time once it is advanced
+// by d.
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	return c.addTimer(d, 0).ch
+}
+
+// Sleep blocks until the fake time is advanced by d.
+func (c *FakeClock) Sleep(d time.Duration) {
+	<-c.After(d)
+}
+
+// NewTicker provides a ticker ticking each time the fake time is advanced
+// past the next interval. As with time.Ticker, ticks are dropped for slow
+// receivers.
+func (c *FakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	return c.addTimer(d, d)
+}
+
+func (c *FakeClock) addTimer(d, period time.Duration) *fakeTimer {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	t := &fakeTimer{clock: c, at: c.now.Add(d), period: period, ch: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.ch <- c.now
+		return t
+	}
+	c.timers = append(c.timers, t)
+	c.added.Broadcast()
+	return t
+}
+
+// Advance moves the fake time forward by d, firing the timers and tickers
+// due by then.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.now = c.now.Add(d)
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			pending = append(pending, t)
+			continue
+		}
+		select {
+		case t.ch <- c.now:
+		default:
+		}
+		if t.period == 0 {
+			continue
+		}
+		for !t.at.After(c.now) {
+			t.at = t.at.Add(t.period)
+		}
+		pending = append(pending, t)
+	}
+	c.timers = pending
+}
+
+// BlockUntil blocks until at least n timers, sleepers or tickers wait
+// for the fake time, so that advancing it reaches them.
+func (c *FakeClock) BlockUntil(n int) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	for len(c.timers) < n {
+		c.added.Wait()
+	}
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.ch
+}
+
+// Stop stops the ticker, no more ticks are delivered.
+func (t *fakeTimer) Stop() {
+	c := t.clock
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	for i, timer := range c.timers {
+		if timer == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,113 @@
+// +build unit_tests
+
+package daemon_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tamarakaufler/watcher-daemon/internal/daemon"
+)
+
+func TestFakeClock(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	clock := daemon.NewFakeClock(start)
+
+	after := clock.After(2 * time.Second)
+	tick := clock.NewTicker(time.Second)
+
+	clock.Advance(time.Second)
+	require.Equal(t, start.Add(time.Second), clock.Now())
+	require.Equal(t, time.Second, clock.Since(start))
+	require.Equal(t, start.Add(time.Second), <-tick.C())
+	require.Len(t, after, 0)
+
+	// ticks are dropped while the receiver is slow
+	clock.Advance(time.Second)
+	clock.Advance(time.Second)
+	require.Equal(t, start.Add(2*time.Second), <-after)
+	require.Equal(t, start.Add(2*time.Second), <-tick.C())
+	require.Len(t, tick.C(), 0)
+
+	tick.Stop()
+	clock.Advance(time.Minute)
+	require.Len(t, tick.C(), 0)
+
+	slept := make(chan struct{})
+	go func() {
+		clock.Sleep(time.Second)
+		close(slept)
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+	<-slept
+}
+
+func TestDaemon_Watch_FakeClock(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	clock := daemon.NewFakeClock(start)
+	fs := daemon.NewMemFileSystem()
+	fs.WriteFile("src/a.go", []byte("package a\n"), start)
+
+	handled := make(chan daemon.ChangeSet, 1)
+	d, err := daemon.NewWithEnvironment(map[string]string{},
+		daemon.WithStateDir(""),
+		daemon.WithClock(clock),
+		daemon.WithFileSystem(fs),
+		daemon.WithBasePath("src"),
+		daemon.WithInterval(time.Minute),
+		daemon.WithHandler(func(ctx context.Context, changes daemon.ChangeSet) error {
+			handled <- changes
+			return nil
+		}),
+	)
+	require.Nil(t, err, "daemon creation failure")
+
+	events, unsubscribe := d.Events()
+	defer unsubscribe()
+	scanned := func() {
+		for ev := range events {
+			if ev.Type == daemon.EventScanFinished {
+				return
+			}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.Watch(ctx, nil)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// nothing is scanned until the interval passes
+	clock.BlockUntil(1)
+	clock.Advance(59 * time.Second)
+	require.Empty(t, d.Files())
+
+	clock.Advance(time.Second)
+	scanned()
+	require.Len(t, d.Files(), 1)
+
+	fs.WriteFile("src/a.go", []byte("package a\n\nvar A = 1\n"), clock.Now())
+	clock.Advance(time.Minute)
+	scanned()
+
+	changes := <-handled
+	require.Equal(t, daemon.ChangeSet{{Path: "src/a.go", Name: "a.go", Op: daemon.OpModified}}, changes)
+	require.Eventually(t, func() bool { return len(d.Runs()) == 1 }, time.Second, time.Millisecond)
+
+	run := d.Runs()[0]
+	require.Equal(t, start.Add(2*time.Minute), run.Start)
+	require.Zero(t, run.Duration)
+}
@@ -2,15 +2,17 @@ package daemon
 
 import (
 	"context"
+	"io"
 	"os"
-	"strconv"
+	"path/filepath"
+	"regexp"
 	"strings"
 	"sync"
+	"text/template"
 	"time"
 
 	"github.com/caarlos0/env/v6"
 	"github.com/pkg/errors"
-	"github.com/sirupsen/logrus"
 )
 
 // WatcherDaemon specifies what methods must be implemented
@@ -21,18 +23,54 @@ type WatcherDaemon interface {
 // verifying a Daemon implements all required methods, ie is a WatcherDaemon
 var _ (WatcherDaemon) = (*Daemon)(nil)
 
+// defaultStateDir is the state directory, relative to the base path,
+// unless configured.
+const defaultStateDir = ".watcher-daemon"
+
 // Daemon contains configuration for running the watcher
 type Daemon struct {
 	BasePath  string `env:"WATCHER_DAEMON_BASE_PATH" envDefault:"."`
 	Extention string `env:"WATCHER_DAEMON_EXTENSION" envDefault:".go"`
 	Excluded  string `env:"WATCHER_DAEMON_EXCLUDED" envDefault:""`   // provided as a comma separated string
-	Frequency string `env:"WATCHER_DAEMON_FREQUENCY" envDefault:"5"` // run frequency in seconds
+	Frequency string `env:"WATCHER_DAEMON_FREQUENCY" envDefault:"5"` // Go duration (eg 500ms), seconds if a bare number
+	// IdleFrequency enables adaptive polling: the interval doubles after each
+	// check without changes up to IdleFrequency, and drops back to Frequency
+	// when a change is found
+	IdleFrequency string `env:"WATCHER_DAEMON_IDLE_FREQUENCY" envDefault:""`
+	// Jitter randomly varies each interval by up to the fraction of it (0 to 1)
+	Jitter float64 `env:"WATCHER_DAEMON_JITTER" envDefault:"0"`
+
+	// Roots are directories or single files watched in addition to the base
+	// path, separated by semicolons, each optionally with its own patterns,
+	// eg ../shared?include=*.go,*.tmpl&exclude=vendor;/etc/app/config.yaml
+	Roots string `env:"WATCHER_DAEMON_ROOTS" envDefault:""`
+	// FollowSymlinks watches the files behind symlinks, a symlink pointing
+	// to another target is a change of the files behind it
+	FollowSymlinks bool `env:"WATCHER_DAEMON_FOLLOW_SYMLINKS" envDefault:"false"`
 
-	excluded  []string
-	frequency time.Duration
+	excluded      []string
+	frequency     time.Duration
+	idleFrequency time.Duration
+	roots         []Root
+	// include are file name patterns of the watched files, replacing
+	// the extension when set
+	include []string
+	// backend provides the watched files instead of walking the base path
+	backend Backend
+	// handler handles the changes instead of the command
+	handler Handler
+	// fs is the file system the base path is walked and read in
+	fs FileSystem
+	// clock provides the time for the scans, runs and timeouts
+	clock Clock
 
-	logger   *logrus.Logger
-	LogLevel string `env:"WATCHER_DAEMON_LOG_LEVEL" envDefault:""`
+	logger    Logger
+	LogLevel  string `env:"WATCHER_DAEMON_LOG_LEVEL" envDefault:""`
+	LogFormat string `env:"WATCHER_DAEMON_LOG_FORMAT" envDefault:"text"` // text or json
+
+	// where the command, the service and the hooks write their output
+	stdout io.Writer
+	stderr io.Writer
 
 	// mutex protects sending on the doneChan
 	doneMux  *sync.Mutex
@@ -41,81 +79,397 @@ type Daemon struct {
 	// mutex protects running of the command
 	cmdMux  *sync.Mutex
 	Command string `env:"WATCHER_DAEMON_COMMAND" envDefault:"echo \"Hello world\""`
+
+	// command is a template rendered with the detected changes
+	// (eg {{.Files}} or {{.Packages}}) before each run
+	command *template.Template
+
+	// GoMode maps changed Go files to the affected packages of the module
+	GoMode bool `env:"WATCHER_DAEMON_GO_MODE" envDefault:"false"`
+
+	// SemanticFilter ignores comment-only and formatting-only changes of Go files
+	SemanticFilter bool `env:"WATCHER_DAEMON_SEMANTIC_FILTER" envDefault:"false"`
+
+	// TestSummary runs go test with -json in Go mode and summarises its output
+	TestSummary bool `env:"WATCHER_DAEMON_TEST_SUMMARY" envDefault:"false"`
+
+	// ReportsDir is where JSON and JUnit XML reports of the runs are written (none if empty)
+	ReportsDir string `env:"WATCHER_DAEMON_REPORTS_DIR" envDefault:""`
+	// RunLogs tees the output of each run to a log file in the reports directory
+	RunLogs bool `env:"WATCHER_DAEMON_RUN_LOGS" envDefault:"false"`
+	// ReportsRetention is the number of runs, whose reports are kept (all if 0)
+	ReportsRetention int `env:"WATCHER_DAEMON_REPORTS_RETENTION" envDefault:"20"`
+
+	// StateDir keeps the state of the daemon, eg the run history (not kept if empty).
+	// The default one is in the base path.
+	StateDir string `env:"WATCHER_DAEMON_STATE_DIR" envDefault:".watcher-daemon"`
+
+	// OfflineChanges decides how changes made while the daemon was not running
+	// are handled on startup: always, changed or never
+	OfflineChanges string `env:"WATCHER_DAEMON_OFFLINE_CHANGES" envDefault:"changed"`
+
+	// APIAddr is the address of the status and control API, either a local
+	// host:port or a unix socket path prefixed with unix: (disabled if empty)
+	APIAddr string `env:"WATCHER_DAEMON_API_ADDR" envDefault:""`
+
+	// mutex protects the state of the watch reported by the API
+	stateMux         *sync.Mutex
+	paused           bool
+	lastScan         time.Time
+	lastScanDuration time.Duration
+	lastScanID       int
+	nextCheck        time.Time
+	// used to run the command without a detected change
+	triggerCh chan struct{}
+
+	// LiveReloadAddr is the host:port serving the browser live reload (disabled if empty)
+	LiveReloadAddr string `env:"WATCHER_DAEMON_LIVE_RELOAD_ADDR" envDefault:""`
+	liveReloader   *liveReloader
+
+	// Service is a long running command, eg a dev server, started after each
+	// successful run of the command and stopped before the next run (restart mode)
+	Service    string `env:"WATCHER_DAEMON_SERVICE" envDefault:""`
+	serviceMux *sync.Mutex
+	service    *service
+
+	// ReadyTCP is the host:port the service listens on when ready
+	ReadyTCP string `env:"WATCHER_DAEMON_READY_TCP" envDefault:""`
+	// ReadyHTTP is the URL returning 2xx when the service is ready
+	ReadyHTTP string `env:"WATCHER_DAEMON_READY_HTTP" envDefault:""`
+	// ReadyLog is a regex matching the line the service logs on stdout when ready
+	ReadyLog string `env:"WATCHER_DAEMON_READY_LOG" envDefault:""`
+	readyLog *regexp.Regexp
+	// ReadyTimeout is how long the service has to become ready, in seconds
+	ReadyTimeout int `env:"WATCHER_DAEMON_READY_TIMEOUT" envDefault:"30"`
+
+	// ProxyAddr is the host:port of the reverse proxy to the service (disabled if empty)
+	ProxyAddr string `env:"WATCHER_DAEMON_PROXY_ADDR" envDefault:""`
+	// ProxyTarget is the URL of the service, eg http://localhost:8080
+	ProxyTarget string `env:"WATCHER_DAEMON_PROXY_TARGET" envDefault:""`
+	// ProxyTimeout is how long requests are held while the service restarts, in seconds
+	ProxyTimeout int `env:"WATCHER_DAEMON_PROXY_TIMEOUT" envDefault:"30"`
+	proxy        *proxyGate
+
+	// HookPre runs before the command, eg to clear the screen
+	HookPre string `env:"WATCHER_DAEMON_HOOK_PRE" envDefault:""`
+	// HookPost runs after a successful run
+	HookPost string `env:"WATCHER_DAEMON_HOOK_POST" envDefault:""`
+	// HookOnFailure runs after a failed run
+	HookOnFailure string `env:"WATCHER_DAEMON_HOOK_ON_FAILURE" envDefault:""`
+	// HookOnRecovery runs after the first successful run following a failed one
+	HookOnRecovery string `env:"WATCHER_DAEMON_HOOK_ON_RECOVERY" envDefault:""`
+
+	// Webhooks are URLs notified of the run outcomes, provided as a comma separated string
+	Webhooks string `env:"WATCHER_DAEMON_WEBHOOKS" envDefault:""`
+	webhooks []string
+	// WebhookFormat is the payload shape: json or slack
+	WebhookFormat string `env:"WATCHER_DAEMON_WEBHOOK_FORMAT" envDefault:"json"`
+	// WebhookOn are the run outcomes firing the webhooks: failure, recovery,
+	// success or always, provided as a comma separated string
+	WebhookOn string `env:"WATCHER_DAEMON_WEBHOOK_ON" envDefault:"failure,recovery"`
+	webhookOn []string
+	// WebhookRetries is the number of retries of a failed webhook
+	WebhookRetries int `env:"WATCHER_DAEMON_WEBHOOK_RETRIES" envDefault:"3"`
+	// WebhookTimeout is the timeout of each webhook request, in seconds
+	WebhookTimeout int `env:"WATCHER_DAEMON_WEBHOOK_TIMEOUT" envDefault:"10"`
+
+	metrics *metrics
+
+	// EventBuffer is the number of events buffered for each subscriber
+	// of the event stream, further events are dropped for a slow subscriber
+	EventBuffer int `env:"WATCHER_DAEMON_EVENT_BUFFER" envDefault:"100"`
+	events      *broker
+
+	subscriptionBuffer int
+	subscriptionPolicy Policy
+	subscriptions      *subscriptions
+
+	// mutex protects the snapshot of the watched files
+	snapshotMux *sync.Mutex
+	snapshot    Snapshot
+
+	// mutex protects the run history
+	runsMux   *sync.Mutex
+	runs      []Run
+	lastRunID int
+	// the last run recorded in the history before the daemon started
+	recordedRun *Run
+
+	// mutex protects the code of Go files used by the semantic filter,
+	// hashes of their syntax trees by path
+	goCodeMux *sync.Mutex
+	goCode    map[string]string
+}
+
+// StateDirFromEnvironment provides the state directory configured by the
+// WATCHER_DAEMON_* variables of the given environment (the process
+// environment if nil), without the rest of the configuration of a Daemon.
+func StateDirFromEnvironment(environment map[string]string) (string, error) {
+	var cfg struct {
+		BasePath string `env:"WATCHER_DAEMON_BASE_PATH" envDefault:"."`
+		StateDir string `env:"WATCHER_DAEMON_STATE_DIR" envDefault:".watcher-daemon"`
+	}
+	if err := env.Parse(&cfg, env.Options{Environment: environment}); err != nil {
+		return "", errors.Wrap(err, "cannot read the state directory")
+	}
+	return resolveStateDir(cfg.StateDir, cfg.BasePath), nil
+}
+
+// resolveStateDir places the default state directory in the watched tree
+// rather than in the current directory, which daemons watching other trees
+// may share.
+func resolveStateDir(stateDir, basePath string) string {
+	if stateDir == defaultStateDir {
+		return filepath.Join(basePath, defaultStateDir)
+	}
+	return stateDir
 }
 
 // New is a constructor providing a new instance of a Daemon
-func New() (*Daemon, error) {
-	d := &Daemon{}
-	err := env.Parse(d)
+func New(opts ...Option) (*Daemon, error) {
+	return NewWithEnvironment(nil, opts...)
+}
+
+// NewWithEnvironment creates a Daemon configured by the WATCHER_DAEMON_*
+// variables of the given environment (the process environment if nil)
+// and by the options.
+func NewWithEnvironment(environment map[string]string, opts ...Option) (*Daemon, error) {
+	d := &Daemon{
+		stdout:             os.Stdout,
+		stderr:             os.Stderr,
+		fs:                 OSFileSystem{},
+		clock:              systemClock{},
+		subscriptionBuffer: defaultSubscriptionBuffer,
+		subscriptionPolicy: PolicyDrop,
+	}
+	err := env.Parse(d, env.Options{Environment: environment})
 	if err != nil {
 		return nil, errors.Wrap(err, "error creating a Daemon instance")
 	}
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	if d.excluded == nil {
+		d.excluded = strings.Split(d.Excluded, ",")
+	}
 
-	d.excluded = strings.Split(d.Excluded, ",")
+	d.StateDir = resolveStateDir(d.StateDir, d.BasePath)
 
-	fr, err := strconv.Atoi(d.Frequency)
-	if err != nil {
+	if d.roots == nil {
+		d.roots, err = parseRoots(d.Roots)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid roots")
+		}
+	}
+	if err := d.validatePatterns(); err != nil {
+		return nil, err
+	}
+
+	if d.frequency == 0 {
+		d.frequency, err = parseInterval(d.Frequency)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid frequency")
+		}
+	}
+	if d.frequency <= 0 {
+		return nil, errors.Errorf("invalid frequency %s", d.frequency)
+	}
+	if d.idleFrequency == 0 && d.IdleFrequency != "" {
+		d.idleFrequency, err = parseInterval(d.IdleFrequency)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid idle frequency")
+		}
+	}
+	if d.idleFrequency != 0 && d.idleFrequency < d.frequency {
+		return nil, errors.Errorf("idle frequency %s is shorter than the frequency %s", d.idleFrequency, d.frequency)
+	}
+	if d.Jitter < 0 || d.Jitter >= 1 {
+		return nil, errors.Errorf("invalid jitter %v, must be at least 0 and less than 1", d.Jitter)
+	}
+
+	switch d.OfflineChanges {
+	case OfflineAlways, OfflineChanged, OfflineNever:
+	default:
+		return nil, errors.Errorf("unknown handling of offline changes %q", d.OfflineChanges)
+	}
+
+	d.webhooks = splitList(d.Webhooks)
+	d.webhookOn = splitList(d.WebhookOn)
+	if err := validateWebhooks(d.WebhookFormat, d.webhookOn); err != nil {
 		return nil, err
 	}
-	d.frequency = time.Duration(time.Duration(fr) * time.Second)
 
-	d.initialiseLogger()
+	if d.ReadyLog != "" {
+		d.readyLog, err = regexp.Compile(d.ReadyLog)
+		if err != nil {
+			return nil, errors.Wrap(err, "error parsing the readiness log regex")
+		}
+	}
+
+	if d.RunLogs && d.ReportsDir == "" {
+		return nil, errors.New("the run logs require the reports directory")
+	}
+
+	if d.APIAddr != "" {
+		if err := validateAPIAddr(d.APIAddr); err != nil {
+			return nil, err
+		}
+	}
+
+	if d.ProxyAddr != "" && (d.Service == "" || d.ProxyTarget == "") {
+		return nil, errors.New("the proxy requires the service and the proxy target")
+	}
+
+	d.command, err = template.New("command").Parse(d.Command)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing the command")
+	}
+
+	if err := d.initialiseLogger(); err != nil {
+		return nil, err
+	}
+	// run IDs stay unique across restarts of the daemon
+	last, err := d.lastRecordedRun()
+	if err != nil {
+		return nil, err
+	}
+	if last != nil {
+		d.lastRunID = last.ID
+		d.recordedRun = last
+	}
 
 	d.cmdMux = &sync.Mutex{}
 	d.doneMux = &sync.Mutex{}
+	d.goCodeMux = &sync.Mutex{}
+	d.runsMux = &sync.Mutex{}
+	d.snapshotMux = &sync.Mutex{}
+	d.stateMux = &sync.Mutex{}
+	d.serviceMux = &sync.Mutex{}
+
+	d.triggerCh = make(chan struct{}, 1)
+	d.metrics = newMetrics()
+	d.liveReloader = newLiveReloader()
+	d.proxy = newProxyGate()
+	d.events = newBroker(d.EventBuffer)
+	d.subscriptions = newSubscriptions()
 
 	d.doneChan = make(chan struct{})
 
 	return d, err
 }
 
-// Watch watches for changes in files at regular intervals
+// passChanges passes the changes to the command. The command may still be
+// running, so this is done without blocking the watch.
+func (d *Daemon) passChanges(ctx context.Context, changes ChangeSet, doneCh chan ChangeSet) {
+	go func() {
+		d.doneMux.Lock()
+		defer d.doneMux.Unlock()
+
+		select {
+		case doneCh <- changes:
+		case <-ctx.Done():
+		}
+	}()
+}
+
+// Watch watches for changes in files at regular intervals, until the context
+// is done or a signal is received
 func (d *Daemon) Watch(ctx context.Context, sigCh chan os.Signal) {
 	d.logger.Infof("Starting the watcher daemon ⌚ 👀 ... ")
 
-	cmdParts := strings.Split(d.Command, " ")
-
-	// use when a change is detected to avoid processing further files
-	doneCh := make(chan struct{})
+	// use when a change is detected to pass the changes to the command
+	doneCh := make(chan ChangeSet)
 	// use when a change is detected, after successfully running the command,
 	// to cancel already created goroutines
 	cancelCh := make(chan struct{})
 
 	// Starts a gouroutine checking on the run outcome, running the command as required
-	d.runOutcomeChecker(cmdParts, sigCh, doneCh, cancelCh)
+	d.runOutcomeChecker(ctx, sigCh, doneCh, cancelCh)
+
+	if d.APIAddr != "" {
+		go func() {
+			if err := d.ServeAPI(ctx); err != nil {
+				d.logger.Errorf("%s", errors.Wrap(err, "error serving the API"))
+			}
+		}()
+	}
+
+	if d.LiveReloadAddr != "" {
+		go func() {
+			if err := d.ServeLiveReload(ctx); err != nil {
+				d.logger.Errorf("%s", err)
+			}
+		}()
+	}
 
-	tick := time.NewTicker(d.frequency)
+	if d.ProxyAddr != "" {
+		go func() {
+			if err := d.ServeProxy(ctx); err != nil {
+				d.logger.Errorf("%s", err)
+			}
+		}()
+	}
+
+	// in restart mode the command runs on startup to start the service
+	if d.Service != "" {
+		d.deliver(ctx, nil)
+		d.passChanges(ctx, nil, doneCh)
+	}
+
+	interval := d.frequency
+	wait := d.scheduleCheck(d.jittered(interval))
 	for {
 		ctxR, cancel := context.WithCancel(ctx)
 		select {
-		case <-tick.C:
-			files, err := d.CollectFiles(ctxR)
-			if err != nil {
-				d.logger.Warn(err)
-				continue
-			}
-
-			//nolint:lll
-			// Creating a buffered channel will avoid leaking goroutines. This would  // happen if there are still running goroutines after one finds a change
-			// and sends to a done channel. If the done channel is not buffered, then
-			// some of the running gouroutines may also try to send to the done
-			// channel and would be blocked forever, ie would start leaking.
-			doneAllCh := make(chan struct{}, len(files))
-
-			go func(doneAllCh, doneCh chan struct{}) {
-				select {
-				case <-doneAllCh:
-					d.doneMux.Lock()
-					doneCh <- struct{}{}
-					d.doneMux.Unlock()
-				case <-time.After(d.frequency * 2):
-					return
-				}
-			}(doneAllCh, doneCh)
-
-			d.ProcessFilesInParallel(ctxR, files, doneAllCh)
+		case <-wait:
+			d.setNextCheck(time.Time{})
+			changed := d.check(ctx, ctxR, doneCh)
+			interval = d.nextInterval(interval, changed)
+			wait = d.scheduleCheck(d.jittered(interval))
+		case <-d.triggerCh:
+			d.logger.Infof("run of the command triggered")
+			d.deliver(ctx, nil)
+			d.passChanges(ctx, nil, doneCh)
 		case <-cancelCh:
 			cancel()
+		case <-ctx.Done():
+			d.logger.Infof("Stopping the watcher daemon")
+			d.setNextCheck(time.Time{})
+			cancel()
+			d.shutdown()
+			return
+		}
+	}
+}
+
+// check scans the files, unless the watch is paused, and passes the changes
+// to the command, reporting whether there were any.
+func (d *Daemon) check(ctx, scanCtx context.Context, doneCh chan ChangeSet) bool {
+	if d.Paused() {
+		return false
+	}
+
+	changes, changed, err := d.Scan(scanCtx)
+	if err != nil {
+		d.logger.Warnf("%s", err)
+		return false
+	}
+	if !changed {
+		return false
+	}
+	d.deliver(ctx, changes)
+	d.passChanges(ctx, changes, doneCh)
+	return true
+}
+
+// splitList splits a comma separated string, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
 		}
 	}
+	return items
 }
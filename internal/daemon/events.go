@@ -0,0 +1,169 @@
+package daemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// EventType identifies what happened in the daemon.
+type EventType string
+
+const (
+	// EventScanStarted is published when a scan of the watched files starts.
+	EventScanStarted EventType = "scan_started"
+	// EventScanFinished is published when a scan finishes.
+	EventScanFinished EventType = "scan_finished"
+	// EventFileChanged is published for each change detected by a scan.
+	EventFileChanged EventType = "file_changed"
+	// EventRunStarted is published when the command starts.
+	EventRunStarted EventType = "run_started"
+	// EventRunOutput is published for each line of the command output.
+	EventRunOutput EventType = "run_output"
+	// EventRunFinished is published when the command finishes.
+	EventRunFinished EventType = "run_finished"
+)
+
+// Event describes something that happened in the daemon.
+type Event struct {
+	Type     EventType     `json:"type"`
+	Time     time.Time     `json:"time"`
+	Files    int           `json:"files,omitempty"`    // number of watched files (scan finished)
+	Duration time.Duration `json:"duration,omitempty"` // scan duration (scan finished)
+	Change   *Change       `json:"change,omitempty"`   // file changed
+	RunID    int           `json:"run_id,omitempty"`   // run started, output and finished
+	Line     string        `json:"line,omitempty"`     // run output
+	Run      *Run          `json:"run,omitempty"`      // run finished
+}
+
+// broker passes published events to subscribers. Each subscriber has
+// a bounded buffer, events are dropped for subscribers, whose buffer is
+// full, so that a slow subscriber never blocks the publisher.
+type broker struct {
+	mux         sync.Mutex
+	subscribers map[chan Event]struct{}
+	bufferSize  int
+}
+
+func newBroker(bufferSize int) *broker {
+	return &broker{
+		subscribers: map[chan Event]struct{}{},
+		bufferSize:  bufferSize,
+	}
+}
+
+// subscribe provides a channel receiving the published events and
+// a function to cancel the subscription.
+func (b *broker) subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, b.bufferSize)
+
+	b.mux.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mux.Unlock()
+
+	return ch, func() {
+		b.mux.Lock()
+		defer b.mux.Unlock()
+
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+func (b *broker) publish(ev Event) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// Events provides a channel receiving events of the daemon and a function
+// to cancel the subscription. Events are dropped when the channel buffer
+// is full.
+func (d *Daemon) Events() (<-chan Event, func()) {
+	return d.events.subscribe()
+}
+
+func (d *Daemon) publish(ev Event) {
+	ev.Time = d.clock.Now()
+	d.events.publish(ev)
+}
+
+// EventsHandler streams the events as Server-Sent Events.
+func (d *Daemon) EventsHandler() http.Handler {
+	return get(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "streaming not supported"})
+			return
+		}
+
+		events, cancel := d.Events()
+		defer cancel()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case ev := <-events:
+				data, err := json.Marshal(ev)
+				if err != nil {
+					continue
+				}
+				if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data); err != nil {
+					return
+				}
+				flusher.Flush()
+			}
+		}
+	})
+}
+
+// lineWriter publishes each complete line written to it as run output.
+type lineWriter struct {
+	mux     sync.Mutex
+	buf     []byte
+	publish func(line string)
+}
+
+func (lw *lineWriter) Write(p []byte) (int, error) {
+	lw.mux.Lock()
+	defer lw.mux.Unlock()
+
+	lw.buf = append(lw.buf, p...)
+	for {
+		i := bytes.IndexByte(lw.buf, '\n')
+		if i < 0 {
+			break
+		}
+		lw.publish(string(lw.buf[:i]))
+		lw.buf = lw.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush publishes the last line not terminated by a new line.
+func (lw *lineWriter) Flush() {
+	lw.mux.Lock()
+	defer lw.mux.Unlock()
+
+	if len(lw.buf) != 0 {
+		lw.publish(string(lw.buf))
+		lw.buf = nil
+	}
+}
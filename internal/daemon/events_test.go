@@ -0,0 +1,134 @@
+// +build unit_tests
+
+package daemon_test
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tamarakaufler/watcher-daemon/internal/daemon"
+)
+
+func newEventsDaemon(t *testing.T, buffer string) *daemon.Daemon {
+	t.Helper()
+
+	d, err := daemon.NewWithEnvironment(map[string]string{
+		"WATCHER_DAEMON_BASE_PATH":    "fixtures/basepath",
+		"WATCHER_DAEMON_EXTENSION":    ".go",
+		"WATCHER_DAEMON_COMMAND":      "printf one\\ntwo\\nthree",
+		"WATCHER_DAEMON_STATE_DIR":    t.TempDir(),
+		"WATCHER_DAEMON_EVENT_BUFFER": buffer,
+	})
+	require.Nil(t, err, "daemon creation failure")
+	return d
+}
+
+func TestDaemon_Events(t *testing.T) {
+	d := newEventsDaemon(t, "100")
+
+	events, cancel := d.Events()
+	defer cancel()
+
+	ctx := context.Background()
+	_, _, err := d.Scan(ctx)
+	require.Nil(t, err)
+	d.RunCommand(ctx, daemon.ChangeSet{{Path: "fixtures/basepath/test.go", Name: "test.go", Op: daemon.OpModified}})
+
+	var got []daemon.EventType
+	var lines []string
+	for len(events) != 0 {
+		ev := <-events
+		got = append(got, ev.Type)
+		if ev.Type == daemon.EventRunOutput {
+			require.Equal(t, 1, ev.RunID)
+			lines = append(lines, ev.Line)
+		}
+		if ev.Type == daemon.EventRunFinished {
+			require.NotNil(t, ev.Run)
+			require.True(t, ev.Run.Succeeded())
+		}
+	}
+	require.Equal(t, []daemon.EventType{
+		daemon.EventScanStarted,
+		daemon.EventScanFinished,
+		daemon.EventRunStarted,
+		daemon.EventRunOutput,
+		daemon.EventRunOutput,
+		daemon.EventRunOutput,
+		daemon.EventRunFinished,
+	}, got)
+	require.Equal(t, []string{"one", "two", "three"}, lines)
+
+	cancel()
+	_, ok := <-events
+	require.False(t, ok, "events channel must be closed after cancelling")
+}
+
+func TestDaemon_Events_SlowSubscriber(t *testing.T) {
+	d := newEventsDaemon(t, "2")
+
+	events, cancel := d.Events()
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.RunCommand(context.Background(), nil)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("slow subscriber blocked the run")
+	}
+
+	require.Len(t, events, 2, "events beyond the buffer must be dropped")
+	require.Equal(t, daemon.EventRunStarted, (<-events).Type)
+	require.Equal(t, daemon.EventRunOutput, (<-events).Type)
+}
+
+func TestClient_Events(t *testing.T) {
+	d := newEventsDaemon(t, "100")
+
+	srv := httptest.NewServer(d.APIHandler())
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	connected := make(chan struct{})
+	go func() {
+		// the stream is open once the client receives the first event
+		for {
+			select {
+			case <-connected:
+				d.RunCommand(ctx, nil)
+				return
+			case <-time.After(10 * time.Millisecond):
+				_, _, _ = d.Scan(ctx)
+			}
+		}
+	}()
+
+	errDone := errors.New("done")
+	var lines []string
+	var connectedOnce bool
+	err := daemon.NewClient(srv.Listener.Addr().String()).Events(ctx, func(ev daemon.Event) error {
+		if !connectedOnce {
+			connectedOnce = true
+			close(connected)
+		}
+		switch ev.Type {
+		case daemon.EventRunOutput:
+			lines = append(lines, ev.Line)
+		case daemon.EventRunFinished:
+			return errDone
+		}
+		return nil
+	})
+	require.Equal(t, errDone, err)
+	require.Equal(t, []string{"one", "two", "three"}, lines)
+}
@@ -0,0 +1,9 @@
+package gotests
+
+import "testing"
+
+func TestPass(t *testing.T) {}
+
+func TestSkip(t *testing.T) {
+	t.Skip("skipped")
+}
@@ -0,0 +1,340 @@
+package daemon
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// FileSystem is the tree of files watched by the daemon.
+type FileSystem interface {
+	// Stat describes the named file, following symlinks.
+	Stat(name string) (os.FileInfo, error)
+	// Walk walks the tree rooted at root as filepath.Walk does, without
+	// following symlinks.
+	Walk(root string, fn filepath.WalkFunc) error
+	ReadFile(name string) ([]byte, error)
+	// EvalSymlinks provides the path of the file with all symlinks resolved.
+	EvalSymlinks(name string) (string, error)
+}
+
+// OSFileSystem is the file system of the operating system.
+type OSFileSystem struct{}
+
+// Stat describes the named file.
+func (OSFileSystem) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+// Walk walks the tree rooted at root.
+func (OSFileSystem) Walk(root string, fn filepath.WalkFunc) error {
+	return filepath.Walk(root, fn)
+}
+
+// ReadFile reads the content of the named file.
+func (OSFileSystem) ReadFile(name string) ([]byte, error) {
+	return ioutil.ReadFile(name)
+}
+
+// EvalSymlinks resolves the symlinks in the path.
+func (OSFileSystem) EvalSymlinks(name string) (string, error) {
+	return filepath.EvalSymlinks(name)
+}
+
+// maxSymlinks is how many symlinks are resolved in a path at most.
+const maxSymlinks = 40
+
+// MemFileSystem is an in-memory file system, eg for tests or virtual trees.
+// Directories exist implicitly as parents of the files and symlinks.
+type MemFileSystem struct {
+	mux   sync.Mutex
+	files map[string]memFile
+	// links maps the symlinks to their targets
+	links map[string]string
+}
+
+type memFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+// NewMemFileSystem creates an empty in-memory file system.
+func NewMemFileSystem() *MemFileSystem {
+	return &MemFileSystem{files: map[string]memFile{}, links: map[string]string{}}
+}
+
+// WriteFile creates or replaces the named file.
+func (m *MemFileSystem) WriteFile(name string, data []byte, modTime time.Time) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	m.files[filepath.Clean(name)] = memFile{
+		data:    append([]byte(nil), data...),
+		modTime: modTime,
+	}
+}
+
+// Symlink creates or replaces the symlink name pointing to the target,
+// which is relative to the directory of the symlink unless absolute.
+func (m *MemFileSystem) Symlink(target, name string) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	m.links[filepath.Clean(name)] = target
+}
+
+// Remove removes the named file or symlink.
+func (m *MemFileSystem) Remove(name string) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	name = filepath.Clean(name)
+	if _, ok := m.links[name]; ok {
+		delete(m.links, name)
+		return nil
+	}
+	if _, ok := m.files[name]; !ok {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	delete(m.files, name)
+	return nil
+}
+
+// Stat describes the named file or directory, following symlinks.
+func (m *MemFileSystem) Stat(name string) (os.FileInfo, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	real, err := m.resolve(name, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return m.lstat(name, real)
+}
+
+// lstat describes the file, directory or symlink at the path, named
+// after the name.
+func (m *MemFileSystem) lstat(name, path string) (os.FileInfo, error) {
+	if f, ok := m.files[path]; ok {
+		return memFileInfo{name: filepath.Base(name), size: int64(len(f.data)), modTime: f.modTime}, nil
+	}
+	if target, ok := m.links[path]; ok {
+		return memFileInfo{name: filepath.Base(name), size: int64(len(target)), link: true}, nil
+	}
+	if m.isDir(path) {
+		return memFileInfo{name: filepath.Base(name), dir: true}, nil
+	}
+	return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+}
+
+// ReadFile reads the content of the named file, following symlinks.
+func (m *MemFileSystem) ReadFile(name string) ([]byte, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	real, err := m.resolve(name, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	f, ok := m.files[real]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return append([]byte(nil), f.data...), nil
+}
+
+// EvalSymlinks provides the path of the existing file with all symlinks
+// resolved.
+func (m *MemFileSystem) EvalSymlinks(name string) (string, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	real, err := m.resolve(name, 0)
+	if err != nil {
+		return "", &os.PathError{Op: "lstat", Path: name, Err: err}
+	}
+	if _, err := m.lstat(real, real); err != nil {
+		return "", err
+	}
+	return real, nil
+}
+
+// resolve resolves the symlinks in each element of the path, counting
+// the symlinks resolved so far.
+func (m *MemFileSystem) resolve(name string, resolved int) (string, error) {
+	name = filepath.Clean(name)
+	var path string
+	if filepath.IsAbs(name) {
+		path = string(filepath.Separator)
+	}
+
+	for _, elem := range strings.Split(name, string(filepath.Separator)) {
+		if elem == "" {
+			continue
+		}
+		path = filepath.Join(path, elem)
+
+		target, ok := m.links[path]
+		if !ok {
+			continue
+		}
+		if resolved++; resolved > maxSymlinks {
+			return "", errors.New("too many levels of symbolic links")
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		var err error
+		if path, err = m.resolve(target, resolved); err != nil {
+			return "", err
+		}
+	}
+	if path == "" {
+		return ".", nil
+	}
+	return path, nil
+}
+
+// Walk walks the tree rooted at root in lexical order, as filepath.Walk does,
+// without following symlinks. The files changed during the walk may or may not
+// be visited.
+func (m *MemFileSystem) Walk(root string, fn filepath.WalkFunc) error {
+	m.mux.Lock()
+	// as with os.Lstat, only the last element of the root is not resolved
+	real, err := m.resolve(filepath.Dir(root), 0)
+	var info os.FileInfo
+	if err == nil {
+		real = filepath.Join(real, filepath.Base(root))
+		info, err = m.lstat(root, real)
+	}
+	m.mux.Unlock()
+	if err != nil {
+		return fn(root, nil, err)
+	}
+	err = m.walk(root, real, info, fn)
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+// walk walks the tree at the real path, named path by the walk.
+func (m *MemFileSystem) walk(path, real string, info os.FileInfo, fn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return fn(path, info, nil)
+	}
+
+	if err := fn(path, info, nil); err != nil {
+		return err
+	}
+	for _, name := range m.children(real) {
+		child := filepath.Join(real, name)
+		m.mux.Lock()
+		info, err := m.lstat(child, child)
+		m.mux.Unlock()
+		if err != nil {
+			// removed during the walk
+			continue
+		}
+		if err := m.walk(filepath.Join(path, name), child, info, fn); err != nil {
+			if !info.IsDir() || err != filepath.SkipDir {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// children provides the sorted names of the files and directories
+// in the directory.
+func (m *MemFileSystem) children(dir string) []string {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	names := map[string]bool{}
+	add := func(name string) {
+		if rel, ok := relative(filepath.Clean(dir), name); ok {
+			names[strings.SplitN(rel, string(filepath.Separator), 2)[0]] = true
+		}
+	}
+	for name := range m.files {
+		add(name)
+	}
+	for name := range m.links {
+		add(name)
+	}
+
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// isDir reports whether the name is a parent directory of any file
+// or symlink.
+func (m *MemFileSystem) isDir(name string) bool {
+	for f := range m.files {
+		if _, ok := relative(name, f); ok {
+			return true
+		}
+	}
+	for l := range m.links {
+		if _, ok := relative(name, l); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// relative provides the path of the file relative to the directory,
+// if the file is in the directory tree.
+func relative(dir, file string) (string, bool) {
+	if dir == "." {
+		if filepath.IsAbs(file) || file == "." {
+			return "", false
+		}
+		return file, true
+	}
+	prefix := dir + string(filepath.Separator)
+	if dir == string(filepath.Separator) {
+		prefix = dir
+	}
+	if !strings.HasPrefix(file, prefix) {
+		return "", false
+	}
+	return file[len(prefix):], true
+}
+
+// memFileInfo describes a file or directory of MemFileSystem.
+type memFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+	link    bool
+}
+
+func (fi memFileInfo) Name() string       { return fi.name }
+func (fi memFileInfo) Size() int64        { return fi.size }
+func (fi memFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi memFileInfo) IsDir() bool        { return fi.dir }
+func (fi memFileInfo) Sys() interface{}   { return nil }
+
+func (fi memFileInfo) Mode() os.FileMode {
+	switch {
+	case fi.dir:
+		return os.ModeDir | 0755
+	case fi.link:
+		return os.ModeSymlink | 0777
+	default:
+		return 0644
+	}
+}
@@ -0,0 +1,180 @@
+// +build unit_tests
+
+package daemon_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tamarakaufler/watcher-daemon/internal/daemon"
+)
+
+func TestMemFileSystem_Walk(t *testing.T) {
+	t.Parallel()
+
+	// the in-memory copy of the fixtures is walked as the fixtures on disk
+	mem := daemon.NewMemFileSystem()
+	var want []string
+	err := daemon.OSFileSystem{}.Walk("fixtures/basepath", func(path string, info os.FileInfo, err error) error {
+		require.Nil(t, err)
+		want = append(want, path)
+		if !info.IsDir() {
+			content, err := ioutil.ReadFile(path)
+			require.Nil(t, err)
+			mem.WriteFile(path, content, info.ModTime())
+		}
+		return nil
+	})
+	require.Nil(t, err)
+
+	var got []string
+	err = mem.Walk("fixtures/basepath", func(path string, info os.FileInfo, err error) error {
+		require.Nil(t, err)
+		got = append(got, path)
+		return nil
+	})
+	require.Nil(t, err)
+	require.Equal(t, want, got)
+
+	var skipped []string
+	err = mem.Walk("fixtures", func(path string, info os.FileInfo, err error) error {
+		if info.IsDir() && info.Name() == "subdir1" {
+			return filepath.SkipDir
+		}
+		skipped = append(skipped, path)
+		return nil
+	})
+	require.Nil(t, err)
+	require.NotContains(t, skipped, "fixtures/basepath/subdir1/test.go")
+	require.Contains(t, skipped, "fixtures/basepath/subdir2/test.go")
+
+	err = mem.Walk("nonexistent", func(path string, info os.FileInfo, err error) error {
+		return err
+	})
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestMemFileSystem_StatReadFile(t *testing.T) {
+	t.Parallel()
+
+	modTime := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	mem := daemon.NewMemFileSystem()
+	mem.WriteFile("src/main.go", []byte("package main\n"), modTime)
+
+	info, err := mem.Stat("src/main.go")
+	require.Nil(t, err)
+	require.Equal(t, "main.go", info.Name())
+	require.Equal(t, int64(13), info.Size())
+	require.Equal(t, modTime, info.ModTime())
+	require.False(t, info.IsDir())
+
+	info, err = mem.Stat("src")
+	require.Nil(t, err)
+	require.True(t, info.IsDir())
+
+	content, err := mem.ReadFile("src/main.go")
+	require.Nil(t, err)
+	require.Equal(t, "package main\n", string(content))
+
+	require.Nil(t, mem.Remove("src/main.go"))
+	_, err = mem.Stat("src")
+	require.True(t, os.IsNotExist(err))
+	_, err = mem.ReadFile("src/main.go")
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestDaemon_Scan_MemFileSystem(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	modTime := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	mem := daemon.NewMemFileSystem()
+	mem.WriteFile("src/a.go", []byte("package a\n"), modTime)
+	mem.WriteFile("src/b.go", []byte("package b\n"), modTime)
+	mem.WriteFile("src/notes.txt", []byte("notes\n"), modTime)
+
+	d, err := daemon.NewWithEnvironment(map[string]string{
+		"WATCHER_DAEMON_SEMANTIC_FILTER": "true",
+	},
+		daemon.WithFileSystem(mem),
+		daemon.WithBasePath("src"),
+		daemon.WithStateDir(""),
+	)
+	require.Nil(t, err)
+
+	_, _, err = d.Scan(ctx)
+	require.Nil(t, err)
+
+	mem.WriteFile("src/a.go", []byte("package a\n\nvar A = 1\n"), modTime.Add(time.Second))
+	// a comment does not change the code
+	mem.WriteFile("src/b.go", []byte("// Package b.\npackage b\n"), modTime.Add(time.Second))
+	mem.WriteFile("src/c.go", []byte("package c\n"), modTime)
+	mem.WriteFile("src/notes.txt", []byte("more notes\n"), modTime.Add(time.Second))
+
+	changes, changed, err := d.Scan(ctx)
+	require.Nil(t, err)
+	require.True(t, changed)
+	require.ElementsMatch(t, daemon.ChangeSet{
+		{Path: "src/a.go", Name: "a.go", Op: daemon.OpModified},
+		{Path: "src/c.go", Name: "c.go", Op: daemon.OpCreated},
+	}, changes)
+
+	require.Nil(t, mem.Remove("src/c.go"))
+	changes, changed, err = d.Scan(ctx)
+	require.Nil(t, err)
+	require.True(t, changed)
+	require.Equal(t, daemon.ChangeSet{
+		{Path: "src/c.go", Name: "c.go", Op: daemon.OpRemoved},
+	}, changes)
+
+	_, changed, err = d.Scan(ctx)
+	require.Nil(t, err)
+	require.False(t, changed)
+}
+
+func TestDaemon_Scan_SemanticFilterOfflineChanges(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	modTime := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	stateDir := t.TempDir()
+
+	mem := daemon.NewMemFileSystem()
+	mem.WriteFile("src/a.go", []byte("package a\n"), modTime)
+	mem.WriteFile("src/b.go", []byte("package b\n"), modTime)
+	newDaemon := func() *daemon.Daemon {
+		d, err := daemon.NewWithEnvironment(map[string]string{
+			"WATCHER_DAEMON_SEMANTIC_FILTER": "true",
+		},
+			daemon.WithFileSystem(mem),
+			daemon.WithBasePath("src"),
+			daemon.WithStateDir(stateDir),
+		)
+		require.Nil(t, err)
+		return d
+	}
+
+	_, _, err := newDaemon().Scan(ctx)
+	require.Nil(t, err)
+
+	// the files change while the daemon is not running
+	mem.WriteFile("src/a.go", []byte("// Package a.\npackage a\n"), modTime.Add(time.Second))
+	mem.WriteFile("src/b.go", []byte("package b\n\nvar B = 1\n"), modTime.Add(time.Second))
+	mem.WriteFile("src/c.go", []byte("package c\n"), modTime)
+
+	// the code persisted with the snapshot is compared, a file without
+	// a known previous code is kept
+	changes, changed, err := newDaemon().Scan(ctx)
+	require.Nil(t, err)
+	require.True(t, changed)
+	require.Equal(t, daemon.ChangeSet{
+		{Path: "src/b.go", Name: "b.go", Op: daemon.OpModified},
+		{Path: "src/c.go", Name: "c.go", Op: daemon.OpCreated},
+	}, changes)
+}
@@ -0,0 +1,146 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// AllPackages is used in the command instead of individual packages
+// when the whole module is affected by a change.
+const AllPackages = "./..."
+
+// goModuleFiles are watched in Go mode in addition to files with the
+// configured extension. Their change affects the whole module.
+var goModuleFiles = map[string]bool{
+	"go.mod": true,
+	"go.sum": true,
+}
+
+// goPackage contains the subset of `go list -json` output needed
+// to work out which packages are affected by a change.
+type goPackage struct {
+	Dir          string
+	ImportPath   string
+	Imports      []string
+	TestImports  []string
+	XTestImports []string
+}
+
+// AffectedPackages maps changed files to their packages and returns them
+// together with all packages within the module that depend on them,
+// directly or through their tests.
+func (d *Daemon) AffectedPackages(ctx context.Context, changes ChangeSet) ([]string, error) {
+	for _, c := range changes {
+		if goModuleFiles[filepath.Base(c.Path)] {
+			return []string{AllPackages}, nil
+		}
+	}
+
+	pkgs, err := d.listPackages(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	byDir := map[string]string{}
+	importers := map[string][]string{}
+	for _, p := range pkgs {
+		byDir[p.Dir] = p.ImportPath
+
+		for _, imports := range [][]string{p.Imports, p.TestImports, p.XTestImports} {
+			for _, imp := range imports {
+				importers[imp] = append(importers[imp], p.ImportPath)
+			}
+		}
+	}
+
+	affected := map[string]bool{}
+	var queue []string
+	for _, c := range changes {
+		dir, err := filepath.Abs(filepath.Dir(c.Path))
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot resolve directory of %s", c.Path)
+		}
+		ip, ok := byDir[dir]
+		if !ok {
+			// the file does not belong to any package known to go list,
+			// so it is safer to run the command for the whole module
+			d.log(ctx).Debugf("no package found for %s", c.Path)
+			return []string{AllPackages}, nil
+		}
+		if !affected[ip] {
+			affected[ip] = true
+			queue = append(queue, ip)
+		}
+	}
+
+	for len(queue) > 0 {
+		ip := queue[0]
+		queue = queue[1:]
+		for _, imp := range importers[ip] {
+			if !affected[imp] {
+				affected[imp] = true
+				queue = append(queue, imp)
+			}
+		}
+	}
+
+	result := make([]string, 0, len(affected))
+	for ip := range affected {
+		result = append(result, ip)
+	}
+	sort.Strings(result)
+
+	return result, nil
+}
+
+// listPackages lists all packages of the module the base path belongs to.
+func (d *Daemon) listPackages(ctx context.Context) ([]goPackage, error) {
+	cmd := exec.CommandContext(ctx, "go", "list", "-m", "-f", "{{.Dir}}")
+	cmd.Dir = d.BasePath
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot find Go module for %s", d.BasePath)
+	}
+	modDir := strings.TrimSpace(string(out))
+
+	cmd = exec.CommandContext(ctx, "go", "list", "-e", "-json", AllPackages)
+	cmd.Dir = modDir
+	out, err = cmd.Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot list packages of module in %s", modDir)
+	}
+
+	var pkgs []goPackage
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for {
+		var p goPackage
+		err := dec.Decode(&p)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot decode go list output")
+		}
+		pkgs = append(pkgs, p)
+	}
+	return pkgs, nil
+}
+
+// isWatched decides if a file is a candidate for the watch.
+func (d *Daemon) isWatched(path string) bool {
+	if d.GoMode && goModuleFiles[filepath.Base(path)] {
+		return true
+	}
+	if len(d.include) != 0 {
+		return d.isIncluded(path)
+	}
+	return filepath.Ext(path) == d.Extention
+}
@@ -0,0 +1,127 @@
+// +build unit_tests
+
+package daemon_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tamarakaufler/watcher-daemon/internal/daemon"
+)
+
+func createModule(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":       "module example.com/m\n\ngo 1.15\n",
+		"a/a.go":       "package a\n\nfunc A() {}\n",
+		"b/b.go":       "package b\n\nimport \"example.com/m/a\"\n\nfunc B() { a.A() }\n",
+		"c/c.go":       "package c\n\nfunc C() {}\n",
+		"d/d.go":       "package d\n",
+		"d/d_test.go":  "package d_test\n\nimport \"example.com/m/b\"\n\nvar _ = b.B\n",
+		"e/e.go":       "package e\n\nimport \"example.com/m/c\"\n\nvar _ = c.C\n",
+		"e/e2/e2.go":   "package e2\n",
+		"notes/readme": "",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.Nil(t, ioutil.WriteFile(path, []byte(content), 0600))
+	}
+	return dir
+}
+
+func TestDaemon_AffectedPackages(t *testing.T) {
+	dir := createModule(t)
+
+	tests := []struct {
+		name    string
+		changed []string
+		want    []string
+	}{
+		{
+			name:    "package without dependants",
+			changed: []string{"e/e2/e2.go"},
+			want:    []string{"example.com/m/e/e2"},
+		},
+		{
+			name:    "package with direct dependants",
+			changed: []string{"c/c.go"},
+			want:    []string{"example.com/m/c", "example.com/m/e"},
+		},
+		{
+			name:    "package with transitive and test dependants",
+			changed: []string{"a/a.go"},
+			want:    []string{"example.com/m/a", "example.com/m/b", "example.com/m/d"},
+		},
+		{
+			name:    "multiple changed packages",
+			changed: []string{"b/b.go", "e/e.go"},
+			want:    []string{"example.com/m/b", "example.com/m/d", "example.com/m/e"},
+		},
+		{
+			name:    "go.mod change affects the whole module",
+			changed: []string{"c/c.go", "go.mod"},
+			want:    []string{daemon.AllPackages},
+		},
+		{
+			name:    "go.sum change affects the whole module",
+			changed: []string{"go.sum"},
+			want:    []string{daemon.AllPackages},
+		},
+		{
+			name:    "file outside of any package affects the whole module",
+			changed: []string{"notes/readme.go"},
+			want:    []string{daemon.AllPackages},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := daemon.NewWithEnvironment(map[string]string{
+				"WATCHER_DAEMON_BASE_PATH": dir,
+				"WATCHER_DAEMON_GO_MODE":   "true",
+			}, daemon.WithStateDir(""))
+			require.Nil(t, err, "daemon creation failure")
+
+			var changes daemon.ChangeSet
+			for _, p := range tt.changed {
+				changes = append(changes, daemon.Change{
+					Path: filepath.Join(dir, p),
+					Name: filepath.Base(p),
+					Op:   daemon.OpModified,
+				})
+			}
+
+			got, err := d.AffectedPackages(context.Background(), changes)
+			require.Nil(t, err)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Daemon.AffectedPackages() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDaemon_BuildCommand(t *testing.T) {
+	dir := createModule(t)
+
+	d, err := daemon.NewWithEnvironment(map[string]string{
+		"WATCHER_DAEMON_BASE_PATH": dir,
+		"WATCHER_DAEMON_GO_MODE":   "true",
+		"WATCHER_DAEMON_COMMAND":   "go test {{.Packages}}",
+	}, daemon.WithStateDir(""))
+	require.Nil(t, err, "daemon creation failure")
+
+	changes := daemon.ChangeSet{
+		{Path: filepath.Join(dir, "c/c.go"), Name: "c.go", Op: daemon.OpModified},
+	}
+	got, err := d.BuildCommand(context.Background(), changes)
+	require.Nil(t, err)
+	require.Equal(t, []string{"go", "test", "example.com/m/c", "example.com/m/e"}, got)
+}
@@ -0,0 +1,200 @@
+package daemon
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// compileErrorRe matches compiler errors reported by go build, go vet
+// and go test, eg: internal/daemon/watch.go:12:3: undefined: x
+var compileErrorRe = regexp.MustCompile(`^(\S+\.go):(\d+)(:\d+)?: (.+)$`)
+
+// TestSummary is a compact summary of go build or go test output.
+type TestSummary struct {
+	Passed      []string `json:"passed,omitempty"`       // passed packages
+	Failed      []string `json:"failed,omitempty"`       // failed packages
+	Skipped     []string `json:"skipped,omitempty"`      // packages without tests
+	FailedTests []string `json:"failed_tests,omitempty"` // failed tests in the form package.TestName
+	Errors      []string `json:"errors,omitempty"`       // compile errors in the form file:line: message
+
+	// results of individual tests, only the failed and skipped ones in the
+	// summary of a run
+	Tests []TestResult `json:"tests,omitempty"`
+}
+
+// TestResult is the result of a single test.
+type TestResult struct {
+	Package string        `json:"package"`
+	Name    string        `json:"name"`
+	Action  string        `json:"action"` // pass, fail or skip
+	Elapsed time.Duration `json:"elapsed"`
+	Output  string        `json:"output,omitempty"`
+}
+
+// failedOrSkipped provides the results of the tests, which did not pass.
+func failedOrSkipped(tests []TestResult) []TestResult {
+	var results []TestResult
+	for _, t := range tests {
+		if t.Action != "pass" {
+			results = append(results, t)
+		}
+	}
+	return results
+}
+
+// testEvent is a single event emitted by go test -json.
+type testEvent struct {
+	Action  string
+	Package string
+	Test    string
+	Output  string
+	Elapsed float64 // seconds
+}
+
+// ParseGoOutput parses output of go build or go test -json. Lines that are
+// not test events are checked for compile errors.
+func ParseGoOutput(r io.Reader) (*TestSummary, error) {
+	summary := &TestSummary{}
+	seenErrors := map[string]bool{}
+	outputs := map[string]*strings.Builder{}
+
+	addError := func(line string) {
+		m := compileErrorRe.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			return
+		}
+		e := fmt.Sprintf("%s:%s: %s", m[1], m[2], m[4])
+		if !seenErrors[e] {
+			seenErrors[e] = true
+			summary.Errors = append(summary.Errors, e)
+		}
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+
+		var ev testEvent
+		if !bytes.HasPrefix(bytes.TrimSpace(line), []byte("{")) || json.Unmarshal(line, &ev) != nil {
+			addError(string(line))
+			continue
+		}
+
+		if ev.Test != "" {
+			key := ev.Package + "." + ev.Test
+			switch ev.Action {
+			case "output":
+				if outputs[key] == nil {
+					outputs[key] = &strings.Builder{}
+				}
+				outputs[key].WriteString(ev.Output)
+			case "pass", "fail", "skip":
+				result := TestResult{
+					Package: ev.Package,
+					Name:    ev.Test,
+					Action:  ev.Action,
+					Elapsed: time.Duration(ev.Elapsed * float64(time.Second)),
+				}
+				if ev.Action != "pass" && outputs[key] != nil {
+					result.Output = outputs[key].String()
+				}
+				delete(outputs, key)
+				summary.Tests = append(summary.Tests, result)
+			}
+		}
+
+		switch ev.Action {
+		case "pass":
+			if ev.Test == "" {
+				summary.Passed = append(summary.Passed, ev.Package)
+			}
+		case "fail":
+			if ev.Test == "" {
+				summary.Failed = append(summary.Failed, ev.Package)
+			} else {
+				summary.FailedTests = append(summary.FailedTests, ev.Package+"."+ev.Test)
+			}
+		case "skip":
+			if ev.Test == "" {
+				summary.Skipped = append(summary.Skipped, ev.Package)
+			}
+		case "build-output":
+			addError(ev.Output)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// testOutputWriter writes the text printed by the tests in place of the
+// events of go test -json written to it. Lines that are not test events
+// are written as they are.
+type testOutputWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+func (tw *testOutputWriter) Write(p []byte) (int, error) {
+	tw.buf = append(tw.buf, p...)
+	for {
+		i := bytes.IndexByte(tw.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if err := tw.writeLine(tw.buf[:i+1]); err != nil {
+			return len(p), err
+		}
+		tw.buf = tw.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush writes the last line not terminated by a new line.
+func (tw *testOutputWriter) Flush() error {
+	if len(tw.buf) == 0 {
+		return nil
+	}
+	err := tw.writeLine(tw.buf)
+	tw.buf = nil
+	return err
+}
+
+func (tw *testOutputWriter) writeLine(line []byte) error {
+	var ev testEvent
+	if !bytes.HasPrefix(bytes.TrimSpace(line), []byte("{")) || json.Unmarshal(line, &ev) != nil {
+		_, err := tw.w.Write(line)
+		return err
+	}
+	switch ev.Action {
+	case "output", "build-output":
+		_, err := io.WriteString(tw.w, ev.Output)
+		return err
+	}
+	return nil
+}
+
+// String provides a compact, human readable form of the summary.
+func (s *TestSummary) String() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%d passed, %d failed, %d skipped packages", len(s.Passed), len(s.Failed), len(s.Skipped))
+	for _, p := range s.Failed {
+		fmt.Fprintf(&b, "\n  FAIL %s", p)
+	}
+	for _, t := range s.FailedTests {
+		fmt.Fprintf(&b, "\n  --- FAIL %s", t)
+	}
+	for _, e := range s.Errors {
+		fmt.Fprintf(&b, "\n  %s", e)
+	}
+	return b.String()
+}
@@ -0,0 +1,107 @@
+// +build unit_tests
+
+package daemon_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tamarakaufler/watcher-daemon/internal/daemon"
+)
+
+func TestParseGoOutput(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		output string
+		want   *daemon.TestSummary
+	}{
+		{
+			name: "passed, failed and skipped packages",
+			output: `{"Action":"run","Package":"example.com/m/a","Test":"TestA"}
+{"Action":"output","Package":"example.com/m/a","Test":"TestA","Output":"    a_test.go:10: want 1, got 2\n"}
+{"Action":"fail","Package":"example.com/m/a","Test":"TestA","Elapsed":0.01}
+{"Action":"fail","Package":"example.com/m/a","Elapsed":0.01}
+{"Action":"run","Package":"example.com/m/b","Test":"TestB"}
+{"Action":"pass","Package":"example.com/m/b","Test":"TestB","Elapsed":0}
+{"Action":"pass","Package":"example.com/m/b","Elapsed":0.01}
+{"Action":"output","Package":"example.com/m/c","Output":"?   \texample.com/m/c\t[no test files]\n"}
+{"Action":"skip","Package":"example.com/m/c","Elapsed":0}
+`,
+			want: &daemon.TestSummary{
+				Passed:      []string{"example.com/m/b"},
+				Failed:      []string{"example.com/m/a"},
+				Skipped:     []string{"example.com/m/c"},
+				FailedTests: []string{"example.com/m/a.TestA"},
+				Tests: []daemon.TestResult{
+					{
+						Package: "example.com/m/a",
+						Name:    "TestA",
+						Action:  "fail",
+						Elapsed: 10 * time.Millisecond,
+						Output:  "    a_test.go:10: want 1, got 2\n",
+					},
+					{
+						Package: "example.com/m/b",
+						Name:    "TestB",
+						Action:  "pass",
+						Elapsed: 0,
+					},
+				},
+			},
+		},
+		{
+			name: "compile errors in build output events",
+			output: `{"ImportPath":"example.com/m/a","Action":"build-output","Output":"# example.com/m/a\n"}
+{"ImportPath":"example.com/m/a","Action":"build-output","Output":"a/a.go:5:2: undefined: x\n"}
+{"ImportPath":"example.com/m/a","Action":"build-fail"}
+{"Action":"fail","Package":"example.com/m/a","Elapsed":0,"FailedBuild":"example.com/m/a"}
+`,
+			want: &daemon.TestSummary{
+				Failed: []string{"example.com/m/a"},
+				Errors: []string{"a/a.go:5: undefined: x"},
+			},
+		},
+		{
+			name: "compile errors of go build",
+			output: `# example.com/m/a
+a/a.go:5:2: undefined: x
+a/a.go:7:10: cannot use y (variable of type string) as int value in return statement
+a/a.go:5:2: undefined: x
+`,
+			want: &daemon.TestSummary{
+				Errors: []string{
+					"a/a.go:5: undefined: x",
+					"a/a.go:7: cannot use y (variable of type string) as int value in return statement",
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := daemon.ParseGoOutput(strings.NewReader(tt.output))
+			require.Nil(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestTestSummary_String(t *testing.T) {
+	t.Parallel()
+
+	s := &daemon.TestSummary{
+		Passed:      []string{"example.com/m/b"},
+		Failed:      []string{"example.com/m/a"},
+		FailedTests: []string{"example.com/m/a.TestA"},
+		Errors:      []string{"a/a.go:5: undefined: x"},
+	}
+	want := `1 passed, 1 failed, 0 skipped packages
+  FAIL example.com/m/a
+  --- FAIL example.com/m/a.TestA
+  a/a.go:5: undefined: x`
+	require.Equal(t, want, s.String())
+}
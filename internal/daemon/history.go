@@ -0,0 +1,163 @@
+package daemon
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// historyFile is the name of the run history file within the state directory.
+const historyFile = "history.jsonl"
+
+// ReadHistory reads all runs recorded in the history file of the state
+// directory, the most recent run last. A record cut off at the end of
+// the file, eg by a crash while it was written, is skipped.
+func ReadHistory(stateDir string) ([]Run, error) {
+	f, err := os.Open(filepath.Join(stateDir, historyFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot open run history")
+	}
+	defer f.Close()
+
+	// the lines are read whole, as a run with many files or a long output
+	// makes a line of any length
+	var runs []Run
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// the last line is complete only if it ends with a newline
+			return runs, nil
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot read run history")
+		}
+		var run Run
+		if err := json.Unmarshal(line, &run); err != nil {
+			if _, err := reader.Peek(1); err == io.EOF {
+				return runs, nil
+			}
+			return nil, errors.Wrapf(err, "cannot decode run history after %d runs", len(runs))
+		}
+		runs = append(runs, run)
+	}
+}
+
+// appendHistory appends the run to the history file of the state directory.
+func (d *Daemon) appendHistory(run Run) error {
+	if d.StateDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(d.StateDir, 0755); err != nil {
+		return errors.Wrapf(err, "cannot create state directory %s", d.StateDir)
+	}
+
+	data, err := json.Marshal(run)
+	if err != nil {
+		return errors.Wrap(err, "cannot encode run")
+	}
+
+	f, err := os.OpenFile(filepath.Join(d.StateDir, historyFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrap(err, "cannot open run history")
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return errors.Wrap(err, "cannot write run history")
+	}
+	return nil
+}
+
+// historyChunk is how much of the history is read at once, when looking
+// for the last record.
+const historyChunk = 64 * 1024
+
+// lastRecordedRun provides the last run in the history, nil if there is none,
+// without reading the whole history. A record cut off at the end, eg by
+// a crash while it was written, is removed, so that the following records
+// are appended after the last complete one.
+func (d *Daemon) lastRecordedRun() (*Run, error) {
+	if d.StateDir == "" {
+		return nil, nil
+	}
+	path := filepath.Join(d.StateDir, historyFile)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot open run history")
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read run history")
+	}
+	end := info.Size()
+	for end > 0 {
+		line, start, err := lastLine(f, end)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot read run history")
+		}
+		var run Run
+		complete := line[len(line)-1] == '\n'
+		if complete && json.Unmarshal(line, &run) == nil {
+			if end != info.Size() {
+				if err := os.Truncate(path, end); err != nil {
+					return nil, errors.Wrap(err, "cannot remove the incomplete record of the run history")
+				}
+			}
+			return &run, nil
+		}
+		if end != info.Size() {
+			return nil, errors.Errorf("cannot decode the run history, fix or remove %s", path)
+		}
+		d.logger.Warnf("the last record of the run history is incomplete, it is removed")
+		end = start
+	}
+	if info.Size() != 0 {
+		if err := os.Truncate(path, 0); err != nil {
+			return nil, errors.Wrap(err, "cannot remove the incomplete record of the run history")
+		}
+	}
+	return nil, nil
+}
+
+// lastLine provides the last line of the file ending at the offset,
+// together with the offset it starts at.
+func lastLine(f *os.File, end int64) ([]byte, int64, error) {
+	var line []byte
+	start := end
+	for start > 0 {
+		size := int64(historyChunk)
+		if size > start {
+			size = start
+		}
+		chunk := make([]byte, size)
+		if _, err := f.ReadAt(chunk, start-size); err != nil {
+			return nil, 0, err
+		}
+		// the newline ending the line does not end the previous one
+		search := chunk
+		if len(line) == 0 && start == end {
+			search = chunk[:len(chunk)-1]
+		}
+		if i := bytes.LastIndexByte(search, '\n'); i >= 0 {
+			line = append(chunk[i+1:], line...)
+			return line, start - size + int64(i) + 1, nil
+		}
+		line = append(chunk, line...)
+		start -= size
+	}
+	return line, 0, nil
+}
@@ -0,0 +1,131 @@
+// +build unit_tests
+
+package daemon_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tamarakaufler/watcher-daemon/internal/daemon"
+)
+
+func TestReadHistory(t *testing.T) {
+	dir := t.TempDir()
+
+	os.Setenv("WATCHER_DAEMON_BASE_PATH", "fixtures/basepath")
+	os.Setenv("WATCHER_DAEMON_COMMAND", "echo {{.Files}}")
+	os.Setenv("WATCHER_DAEMON_STATE_DIR", dir)
+	defer os.Unsetenv("WATCHER_DAEMON_COMMAND")
+	defer os.Unsetenv("WATCHER_DAEMON_STATE_DIR")
+
+	runs, err := daemon.ReadHistory(dir)
+	require.Nil(t, err)
+	require.Empty(t, runs, "history must be empty before any run")
+
+	changes := daemon.ChangeSet{
+		{Path: "fixtures/basepath/test.go", Name: "test.go", Op: daemon.OpModified},
+	}
+
+	d, err := daemon.New()
+	require.Nil(t, err, "daemon creation failure")
+	d.RunCommand(context.Background(), changes)
+	d.RunCommand(context.Background(), changes)
+
+	// run IDs continue after a restart of the daemon
+	d, err = daemon.New()
+	require.Nil(t, err, "daemon creation failure")
+	d.RunCommand(context.Background(), changes)
+
+	runs, err = daemon.ReadHistory(dir)
+	require.Nil(t, err)
+	require.Len(t, runs, 3)
+	for i, r := range runs {
+		require.Equal(t, i+1, r.ID)
+		require.Equal(t, "echo fixtures/basepath/test.go", r.Command)
+		require.Equal(t, []string{"fixtures/basepath/test.go"}, r.Files)
+		require.Equal(t, "fixtures/basepath/test.go\n", r.Output)
+		require.True(t, r.Succeeded())
+	}
+}
+
+func TestReadHistory_LargeRun(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	newDaemon := func() *daemon.Daemon {
+		d, err := daemon.NewWithEnvironment(map[string]string{
+			"WATCHER_DAEMON_COMMAND": "true",
+		}, daemon.WithStateDir(dir))
+		require.Nil(t, err, "daemon creation failure")
+		return d
+	}
+
+	// the record of the run is longer than any line buffer
+	var changes daemon.ChangeSet
+	for i := 0; i < 3000; i++ {
+		name := fmt.Sprintf("file%04d.go", i)
+		changes = append(changes, daemon.Change{
+			Path: "fixtures/basepath/generated/" + name, Name: name, Op: daemon.OpModified,
+		})
+	}
+	newDaemon().RunCommand(context.Background(), changes)
+	newDaemon().RunCommand(context.Background(), changes[:1])
+
+	runs, err := daemon.ReadHistory(dir)
+	require.Nil(t, err)
+	require.Len(t, runs, 2)
+	require.Len(t, runs[0].Files, 3000)
+	require.Equal(t, 2, runs[1].ID)
+}
+
+func TestNewWithEnvironment_TruncatedHistory(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "history.jsonl")
+	require.Nil(t, ioutil.WriteFile(path, []byte("{\"id\":1}\n{\"id\":"), 0644))
+
+	runs, err := daemon.ReadHistory(dir)
+	require.Nil(t, err)
+	require.Len(t, runs, 1, "the truncated record must be skipped")
+
+	// the truncated record is removed and the run IDs continue
+	d, err := daemon.NewWithEnvironment(map[string]string{
+		"WATCHER_DAEMON_COMMAND": "true",
+	}, daemon.WithStateDir(dir))
+	require.Nil(t, err, "daemon creation failure")
+	run := d.RunCommand(context.Background(), nil)
+	require.Equal(t, 2, run.ID)
+
+	runs, err = daemon.ReadHistory(dir)
+	require.Nil(t, err)
+	require.Len(t, runs, 2)
+	require.Equal(t, 2, runs[1].ID)
+}
+
+func TestNewWithEnvironment_CorruptHistory(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "history.jsonl"), []byte("{\"id\":1}\n{\"id\":\n{\"id\":3}\n"), 0644))
+
+	// a corrupt record before the last one is not dropped silently
+	_, err := daemon.ReadHistory(dir)
+	require.NotNil(t, err)
+}
+
+func TestStateDirFromEnvironment(t *testing.T) {
+	t.Parallel()
+
+	dir, err := daemon.StateDirFromEnvironment(map[string]string{
+		"WATCHER_DAEMON_BASE_PATH": "fixtures/basepath",
+		"WATCHER_DAEMON_LOG_LEVEL": "bogus",
+	})
+	require.Nil(t, err)
+	require.Equal(t, filepath.Join("fixtures/basepath", ".watcher-daemon"), dir)
+}
@@ -0,0 +1,74 @@
+package daemon
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Hooks run at stages of each run.
+const (
+	HookPre        = "pre"
+	HookPost       = "post"
+	HookOnFailure  = "on_failure"
+	HookOnRecovery = "on_recovery"
+)
+
+// runHooks runs the hooks for the outcome of the finished run.
+func (d *Daemon) runHooks(ctx context.Context, run Run, previous *Run) {
+	switch runOutcome(run, previous) {
+	case WebhookOnFailure:
+		d.runHook(ctx, HookOnFailure, d.HookOnFailure, run, true)
+	case WebhookOnRecovery:
+		d.runHook(ctx, HookOnRecovery, d.HookOnRecovery, run, true)
+		d.runHook(ctx, HookPost, d.HookPost, run, true)
+	default:
+		d.runHook(ctx, HookPost, d.HookPost, run, true)
+	}
+}
+
+// runHook runs the hook command with the metadata of the run in its
+// environment. A failing hook is only logged.
+func (d *Daemon) runHook(ctx context.Context, name, hook string, run Run, finished bool) {
+	args := strings.Fields(hook)
+	if len(args) == 0 {
+		return
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = d.BasePath
+	cmd.Env = append(os.Environ(), runEnv(run, finished)...)
+	cmd.Stdout = d.stdout
+	cmd.Stderr = d.stderr
+	if err := cmd.Run(); err != nil {
+		d.log(ctx).Warnf("%s", errors.Wrapf(err, "%s hook failed", name))
+	}
+}
+
+// runEnv describes the run in environment variables, the outcome only
+// once the run finished.
+func runEnv(run Run, finished bool) []string {
+	env := []string{
+		"WATCHER_RUN_ID=" + strconv.Itoa(run.ID),
+		"WATCHER_RUN_FILES=" + strings.Join(run.Files, " "),
+		"WATCHER_RUN_COMMAND=" + run.Command,
+	}
+	if !finished {
+		return env
+	}
+
+	status := "success"
+	if !run.Succeeded() {
+		status = "failure"
+	}
+	return append(env,
+		"WATCHER_RUN_STATUS="+status,
+		"WATCHER_RUN_EXIT_CODE="+strconv.Itoa(run.ExitCode),
+		"WATCHER_RUN_DURATION="+run.Duration.String(),
+		"WATCHER_RUN_ERROR="+run.Error,
+	)
+}
@@ -0,0 +1,97 @@
+// +build unit_tests
+
+package daemon_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tamarakaufler/watcher-daemon/internal/daemon"
+)
+
+func TestDaemon_Hooks(t *testing.T) {
+	dir := t.TempDir()
+	hookLog := filepath.Join(dir, "hooks.log")
+	script := filepath.Join(dir, "hook.sh")
+	require.Nil(t, ioutil.WriteFile(script, []byte(
+		`echo "$1 id=$WATCHER_RUN_ID files=$WATCHER_RUN_FILES status=$WATCHER_RUN_STATUS exit=$WATCHER_RUN_EXIT_CODE" >> `+hookLog+"\n"),
+		0600))
+	hook := func(name string) string {
+		return "sh " + script + " " + name
+	}
+
+	// the command fails until the file exists
+	file := filepath.Join(dir, "built")
+	os.Setenv("WATCHER_DAEMON_BASE_PATH", "fixtures/basepath")
+	os.Setenv("WATCHER_DAEMON_EXTENSION", ".go")
+	os.Setenv("WATCHER_DAEMON_EXCLUDED", "")
+	os.Setenv("WATCHER_DAEMON_COMMAND", "ls "+file)
+	os.Setenv("WATCHER_DAEMON_STATE_DIR", t.TempDir())
+	os.Setenv("WATCHER_DAEMON_HOOK_PRE", hook("pre"))
+	os.Setenv("WATCHER_DAEMON_HOOK_POST", hook("post"))
+	os.Setenv("WATCHER_DAEMON_HOOK_ON_FAILURE", hook("on_failure"))
+	os.Setenv("WATCHER_DAEMON_HOOK_ON_RECOVERY", hook("on_recovery"))
+	defer os.Unsetenv("WATCHER_DAEMON_COMMAND")
+	defer os.Unsetenv("WATCHER_DAEMON_STATE_DIR")
+	defer os.Unsetenv("WATCHER_DAEMON_HOOK_PRE")
+	defer os.Unsetenv("WATCHER_DAEMON_HOOK_POST")
+	defer os.Unsetenv("WATCHER_DAEMON_HOOK_ON_FAILURE")
+	defer os.Unsetenv("WATCHER_DAEMON_HOOK_ON_RECOVERY")
+
+	d, err := daemon.New()
+	require.Nil(t, err, "daemon creation failure")
+
+	ctx := context.Background()
+	changes := daemon.ChangeSet{{Path: "fixtures/basepath/test.go", Name: "test.go", Op: daemon.OpModified}}
+	d.RunCommand(ctx, changes)
+	require.Nil(t, ioutil.WriteFile(file, nil, 0600))
+	d.RunCommand(ctx, changes)
+	d.RunCommand(ctx, nil)
+
+	got, err := ioutil.ReadFile(hookLog)
+	require.Nil(t, err)
+	require.Equal(t, []string{
+		"pre id=1 files=fixtures/basepath/test.go status= exit=",
+		"on_failure id=1 files=fixtures/basepath/test.go status=failure exit=2",
+		"pre id=2 files=fixtures/basepath/test.go status= exit=",
+		"on_recovery id=2 files=fixtures/basepath/test.go status=success exit=0",
+		"post id=2 files=fixtures/basepath/test.go status=success exit=0",
+		"pre id=3 files= status= exit=",
+		"post id=3 files= status=success exit=0",
+	}, strings.Split(strings.TrimSpace(string(got)), "\n"))
+}
+
+func TestDaemon_Hooks_RecoveryAfterRestart(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	hookLog := filepath.Join(dir, "hooks.log")
+	file := filepath.Join(dir, "built")
+	script := filepath.Join(dir, "hook.sh")
+	require.Nil(t, ioutil.WriteFile(script, []byte(`echo "on_recovery $WATCHER_RUN_ID" >> `+hookLog+"\n"), 0600))
+	stateDir := t.TempDir()
+	newDaemon := func() *daemon.Daemon {
+		d, err := daemon.NewWithEnvironment(map[string]string{
+			"WATCHER_DAEMON_COMMAND":          "ls " + file,
+			"WATCHER_DAEMON_HOOK_ON_RECOVERY": "sh " + script,
+		}, daemon.WithStateDir(stateDir))
+		require.Nil(t, err, "daemon creation failure")
+		return d
+	}
+
+	ctx := context.Background()
+	newDaemon().RunCommand(ctx, nil)
+	require.Nil(t, ioutil.WriteFile(file, nil, 0600))
+
+	// the failure recorded before the restart is recovered from
+	newDaemon().RunCommand(ctx, nil)
+
+	got, err := ioutil.ReadFile(hookLog)
+	require.Nil(t, err)
+	require.Equal(t, "on_recovery 2\n", string(got))
+}
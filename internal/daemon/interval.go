@@ -0,0 +1,68 @@
+package daemon
+
+import (
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// parseInterval parses a Go duration, eg 500ms or 2s. A bare number
+// is the number of seconds.
+func parseInterval(s string) (time.Duration, error) {
+	if sec, err := strconv.Atoi(s); err == nil {
+		return time.Duration(sec) * time.Second, nil
+	}
+	interval, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, errors.Errorf("%q is neither a number of seconds nor a duration", s)
+	}
+	return interval, nil
+}
+
+// nextInterval provides the interval before the next check. In adaptive
+// mode, the interval doubles after each check without changes, up to
+// the idle frequency.
+func (d *Daemon) nextInterval(interval time.Duration, changed bool) time.Duration {
+	if d.idleFrequency == 0 || changed {
+		return d.frequency
+	}
+	if interval *= 2; interval > d.idleFrequency {
+		return d.idleFrequency
+	}
+	return interval
+}
+
+// jittered varies the interval randomly by up to the jitter fraction of it.
+func (d *Daemon) jittered(interval time.Duration) time.Duration {
+	if d.Jitter == 0 {
+		return interval
+	}
+	return interval + time.Duration((2*rand.Float64()-1)*d.Jitter*float64(interval))
+}
+
+// scheduleCheck provides a channel receiving when the check after the delay
+// is due and records the time of the check.
+func (d *Daemon) scheduleCheck(delay time.Duration) <-chan time.Time {
+	at := d.clock.Now().Add(delay)
+	wait := d.clock.After(delay)
+	d.setNextCheck(at)
+	return wait
+}
+
+func (d *Daemon) setNextCheck(at time.Time) {
+	d.stateMux.Lock()
+	defer d.stateMux.Unlock()
+
+	d.nextCheck = at
+}
+
+// NextCheck provides the time the files are checked next, zero while they
+// are being checked or the watch is not running.
+func (d *Daemon) NextCheck() time.Time {
+	d.stateMux.Lock()
+	defer d.stateMux.Unlock()
+
+	return d.nextCheck
+}
@@ -0,0 +1,176 @@
+// +build unit_tests
+
+package daemon_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tamarakaufler/watcher-daemon/internal/daemon"
+)
+
+var intervalStart = time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+
+// watchScans watches the in-memory tree, advancing the fake clock by the step
+// the number of times, and provides the times of the scans since the start.
+// The change function, if any, is called before each step with the time
+// since the start.
+func watchScans(t *testing.T, environment map[string]string, steps int, step time.Duration,
+	change func(fs *daemon.MemFileSystem, now time.Duration)) []time.Duration {
+	t.Helper()
+
+	clock := daemon.NewFakeClock(intervalStart)
+	fs := daemon.NewMemFileSystem()
+	fs.WriteFile("src/a.go", []byte("package a\n"), intervalStart)
+
+	d, err := daemon.NewWithEnvironment(environment,
+		daemon.WithStateDir(""),
+		daemon.WithClock(clock),
+		daemon.WithFileSystem(fs),
+		daemon.WithBasePath("src"),
+		daemon.WithHandler(func(context.Context, daemon.ChangeSet) error { return nil }),
+	)
+	require.Nil(t, err, "daemon creation failure")
+
+	events, unsubscribe := d.Events()
+	scansCh := make(chan []time.Duration)
+	go func() {
+		var scans []time.Duration
+		for ev := range events {
+			if ev.Type == daemon.EventScanStarted {
+				scans = append(scans, ev.Time.Sub(intervalStart))
+			}
+		}
+		scansCh <- scans
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.Watch(ctx, nil)
+	}()
+
+	for i := 0; i < steps; i++ {
+		if change != nil {
+			change(fs, clock.Since(intervalStart))
+		}
+		// the watcher waits for the next check
+		clock.BlockUntil(1)
+		clock.Advance(step)
+	}
+	clock.BlockUntil(1)
+
+	cancel()
+	<-done
+	unsubscribe()
+	return <-scansCh
+}
+
+func TestNewWithEnvironment_Frequency(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		environment map[string]string
+		want        time.Duration
+		wantErr     bool
+	}{
+		{
+			name:        "seconds",
+			environment: map[string]string{"WATCHER_DAEMON_FREQUENCY": "3"},
+			want:        3 * time.Second,
+		},
+		{
+			name:        "sub-second duration",
+			environment: map[string]string{"WATCHER_DAEMON_FREQUENCY": "500ms"},
+			want:        500 * time.Millisecond,
+		},
+		{
+			name:        "duration",
+			environment: map[string]string{"WATCHER_DAEMON_FREQUENCY": "1m30s"},
+			want:        90 * time.Second,
+		},
+		{
+			name:        "invalid",
+			environment: map[string]string{"WATCHER_DAEMON_FREQUENCY": "often"},
+			wantErr:     true,
+		},
+		{
+			name:        "zero",
+			environment: map[string]string{"WATCHER_DAEMON_FREQUENCY": "0s"},
+			wantErr:     true,
+		},
+		{
+			name: "idle frequency shorter than the frequency",
+			environment: map[string]string{
+				"WATCHER_DAEMON_FREQUENCY":      "2s",
+				"WATCHER_DAEMON_IDLE_FREQUENCY": "1s",
+			},
+			wantErr: true,
+		},
+		{
+			name:        "jitter out of range",
+			environment: map[string]string{"WATCHER_DAEMON_JITTER": "1"},
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if tt.wantErr {
+				_, err := daemon.NewWithEnvironment(tt.environment, daemon.WithStateDir(""))
+				require.NotNil(t, err)
+				return
+			}
+
+			// the first scan does not happen a step earlier
+			step := tt.want / 100
+			scans := watchScans(t, tt.environment, 100, step, nil)
+			require.Equal(t, []time.Duration{tt.want}, scans)
+		})
+	}
+}
+
+func TestDaemon_AdaptiveFrequency(t *testing.T) {
+	t.Parallel()
+
+	change := func(fs *daemon.MemFileSystem, now time.Duration) {
+		if now == 12*time.Second {
+			fs.WriteFile("src/a.go", []byte("package a\n\nvar A = 1\n"), intervalStart.Add(now))
+		}
+	}
+	scans := watchScans(t, map[string]string{
+		"WATCHER_DAEMON_FREQUENCY":      "1s",
+		"WATCHER_DAEMON_IDLE_FREQUENCY": "4s",
+	}, 22, time.Second, change)
+
+	// the interval doubles while idle and drops back after the change
+	// found at 15s
+	want := []time.Duration{1, 3, 7, 11, 15, 16, 18, 22}
+	for i := range want {
+		want[i] *= time.Second
+	}
+	require.Equal(t, want, scans)
+}
+
+func TestDaemon_Jitter(t *testing.T) {
+	t.Parallel()
+
+	scans := watchScans(t, map[string]string{
+		"WATCHER_DAEMON_FREQUENCY": "1m",
+		"WATCHER_DAEMON_JITTER":    "0.5",
+	}, 180, time.Second, nil)
+
+	require.NotEmpty(t, scans)
+	previous := time.Duration(0)
+	for _, s := range scans {
+		require.GreaterOrEqual(t, int64(s-previous), int64(30*time.Second))
+		require.LessOrEqual(t, int64(s-previous), int64(91*time.Second))
+		previous = s
+	}
+}
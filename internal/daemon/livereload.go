@@ -0,0 +1,153 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// liveReloadScript connects the browser to the live-reload stream. It reloads
+// the page, or only the stylesheets when only CSS files changed.
+const liveReloadScript = `(function () {
+  var script = document.currentScript;
+  var origin = script ? new URL(script.src).origin : "";
+  var source = new EventSource(origin + "/livereload");
+  source.addEventListener("reload", function () {
+    window.location.reload();
+  });
+  source.addEventListener("css", function () {
+    var links = document.querySelectorAll('link[rel="stylesheet"]');
+    for (var i = 0; i < links.length; i++) {
+      var url = new URL(links[i].href);
+      url.searchParams.set("livereload", Date.now());
+      links[i].href = url.toString();
+    }
+  });
+})();
+`
+
+// Live-reload messages sent to the browsers.
+const (
+	reloadPage = "reload"
+	reloadCSS  = "css"
+)
+
+// liveReloader passes reload messages to the connected browsers.
+type liveReloader struct {
+	mux     sync.Mutex
+	clients map[chan string]struct{}
+}
+
+func newLiveReloader() *liveReloader {
+	return &liveReloader{clients: map[chan string]struct{}{}}
+}
+
+func (lr *liveReloader) connect() (chan string, func()) {
+	// a browser needs only the latest pending message
+	ch := make(chan string, 1)
+
+	lr.mux.Lock()
+	lr.clients[ch] = struct{}{}
+	lr.mux.Unlock()
+
+	return ch, func() {
+		lr.mux.Lock()
+		delete(lr.clients, ch)
+		lr.mux.Unlock()
+	}
+}
+
+func (lr *liveReloader) send(msg string) {
+	lr.mux.Lock()
+	defer lr.mux.Unlock()
+
+	for ch := range lr.clients {
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+}
+
+// LiveReload tells the connected browsers to reload after the changes.
+// Only stylesheets are swapped when all changed files are CSS files.
+func (d *Daemon) LiveReload(changes ChangeSet) {
+	msg := reloadCSS
+	if len(changes) == 0 {
+		msg = reloadPage
+	}
+	for _, c := range changes {
+		if filepath.Ext(c.Path) != ".css" {
+			msg = reloadPage
+			break
+		}
+	}
+	d.liveReloader.send(msg)
+}
+
+// LiveReloadHandler serves the live-reload script on /livereload.js
+// and the stream of reload messages on /livereload.
+func (d *Daemon) LiveReloadHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/livereload.js", get(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/javascript")
+		w.Header().Set("Cache-Control", "no-cache")
+		fmt.Fprint(w, liveReloadScript)
+	}))
+	mux.HandleFunc("/livereload", get(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming not supported", http.StatusInternalServerError)
+			return
+		}
+
+		ch, disconnect := d.liveReloader.connect()
+		defer disconnect()
+
+		// the page being reloaded is usually served from another origin
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case msg := <-ch:
+				if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", msg, msg); err != nil {
+					return
+				}
+				flusher.Flush()
+			}
+		}
+	}))
+
+	return mux
+}
+
+// ServeLiveReload serves the live-reload endpoints on the configured address
+// until the context is done.
+func (d *Daemon) ServeLiveReload(ctx context.Context) error {
+	srv := &http.Server{
+		Addr:    d.LiveReloadAddr,
+		Handler: d.LiveReloadHandler(),
+	}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	d.logger.Infof("serving live reload on %s, add <script src=\"http://%s/livereload.js\"></script> to the page",
+		d.LiveReloadAddr, d.LiveReloadAddr)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return errors.Wrapf(err, "cannot serve live reload on %s", d.LiveReloadAddr)
+	}
+	return nil
+}
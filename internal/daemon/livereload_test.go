@@ -0,0 +1,105 @@
+// +build unit_tests
+
+package daemon_test
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tamarakaufler/watcher-daemon/internal/daemon"
+)
+
+func TestDaemon_LiveReloadHandler(t *testing.T) {
+	os.Setenv("WATCHER_DAEMON_BASE_PATH", "fixtures/basepath")
+	os.Setenv("WATCHER_DAEMON_EXTENSION", ".go")
+	os.Setenv("WATCHER_DAEMON_EXCLUDED", "")
+	os.Setenv("WATCHER_DAEMON_STATE_DIR", t.TempDir())
+	defer os.Unsetenv("WATCHER_DAEMON_STATE_DIR")
+
+	d, err := daemon.New()
+	require.Nil(t, err, "daemon creation failure")
+
+	srv := httptest.NewServer(d.LiveReloadHandler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/livereload.js")
+	require.Nil(t, err)
+	script, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.Nil(t, err)
+	require.Equal(t, "application/javascript", resp.Header.Get("Content-Type"))
+	require.Contains(t, string(script), `new EventSource(origin + "/livereload")`)
+
+	resp, err = http.Get(srv.URL + "/livereload")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	require.Equal(t, "*", resp.Header.Get("Access-Control-Allow-Origin"))
+
+	events := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if line := scanner.Text(); strings.HasPrefix(line, "event: ") {
+				events <- strings.TrimPrefix(line, "event: ")
+			}
+		}
+	}()
+
+	tests := []struct {
+		name    string
+		changes daemon.ChangeSet
+		want    string
+	}{
+		{
+			name:    "only stylesheets changed",
+			changes: daemon.ChangeSet{{Path: "web/a.css"}, {Path: "web/b.css"}},
+			want:    "css",
+		},
+		{
+			name:    "stylesheet and template changed",
+			changes: daemon.ChangeSet{{Path: "web/a.css"}, {Path: "web/index.html"}},
+			want:    "reload",
+		},
+		{
+			name: "triggered without changes",
+			want: "reload",
+		},
+	}
+	// the browser is connected once the handler receives a message
+	deadline := time.After(5 * time.Second)
+	for connected := false; !connected; {
+		d.LiveReload(nil)
+		select {
+		case <-events:
+			connected = true
+		case <-time.After(10 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("live reload stream not connected")
+		}
+	}
+	for drained := false; !drained; {
+		select {
+		case <-events:
+		case <-time.After(50 * time.Millisecond):
+			drained = true
+		}
+	}
+
+	for _, tt := range tests {
+		d.LiveReload(tt.changes)
+		select {
+		case got := <-events:
+			require.Equal(t, tt.want, got, tt.name)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: no live reload message received", tt.name)
+		}
+	}
+}
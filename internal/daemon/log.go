@@ -1,8 +1,10 @@
 package daemon
 
 import (
+	"context"
 	"os"
 
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
@@ -10,19 +12,65 @@ var (
 	defaultLogLevel = logrus.InfoLevel
 )
 
-func (d *Daemon) initialiseLogger() {
+// Log formats.
+const (
+	LogText = "text"
+	LogJSON = "json"
+)
+
+// Logger is the logging interface of the daemon. A logrus logger is used
+// unless another logger is provided with WithLogger.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+	// WithField provides a logger adding the field to each line.
+	WithField(key string, value interface{}) Logger
+}
+
+// logrusLogger adapts a logrus entry to the Logger interface.
+type logrusLogger struct {
+	*logrus.Entry
+}
+
+// NewLogrusLogger makes a Logger of the logrus logger.
+func NewLogrusLogger(l *logrus.Logger) Logger {
+	return logrusLogger{logrus.NewEntry(l)}
+}
+
+func (l logrusLogger) WithField(key string, value interface{}) Logger {
+	return logrusLogger{l.Entry.WithField(key, value)}
+}
+
+func (d *Daemon) initialiseLogger() error {
+	if d.logger != nil {
+		// provided with WithLogger
+		return nil
+	}
+
 	defaultLogger := logrus.New()
 
 	defaultLogger.SetLevel(defaultLogLevel)
 	if d.LogLevel != "" {
 		logL, err := logrus.ParseLevel(d.LogLevel)
 		if err != nil {
-			defaultLogger.SetLevel(logL)
+			return errors.Wrap(err, "error parsing the log level")
 		}
+		defaultLogger.SetLevel(logL)
 	}
 
-	output := os.Stdout
-	defaultLogger.SetOutput(output)
+	switch d.LogFormat {
+	case LogText:
+	case LogJSON:
+		defaultLogger.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return errors.Errorf("unknown log format %q", d.LogFormat)
+	}
+
+	// the daemon logs to os.Stdout regardless of WithStdout, which is meant
+	// for the output of the command
+	defaultLogger.SetOutput(os.Stdout)
 
 	defaultFields := logrus.Fields{
 		"service":   "watcher-daemon",
@@ -31,7 +79,31 @@ func (d *Daemon) initialiseLogger() {
 		"excluded":  d.Excluded,
 		"extension": d.Extention,
 	}
-	defaultLogger.WithFields(defaultFields)
 
-	d.logger = defaultLogger
+	d.logger = logrusLogger{defaultLogger.WithFields(defaultFields)}
+	return nil
+}
+
+type loggerKey struct{}
+
+// withScanID makes the lines logged with the context carry the scan ID.
+func (d *Daemon) withScanID(ctx context.Context, id int) context.Context {
+	return context.WithValue(ctx, loggerKey{}, d.logger.WithField("scan_id", id))
+}
+
+// withRunID makes the lines logged with the context carry the run ID.
+func (d *Daemon) withRunID(ctx context.Context, id int) context.Context {
+	return context.WithValue(ctx, loggerKey{}, d.runLogger(id))
+}
+
+func (d *Daemon) runLogger(id int) Logger {
+	return d.logger.WithField("run_id", id)
+}
+
+// log provides the logger carrying the scan or run ID of the context.
+func (d *Daemon) log(ctx context.Context) Logger {
+	if l, ok := ctx.Value(loggerKey{}).(Logger); ok {
+		return l
+	}
+	return d.logger
 }
@@ -0,0 +1,235 @@
+// +build unit_tests
+
+package daemon_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tamarakaufler/watcher-daemon/internal/daemon"
+)
+
+func TestDaemon_JSONLogging(t *testing.T) {
+	// the logger and the command write to the standard output
+	r, w, err := os.Pipe()
+	require.Nil(t, err)
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	os.Setenv("WATCHER_DAEMON_BASE_PATH", "fixtures/basepath")
+	os.Setenv("WATCHER_DAEMON_EXTENSION", ".go")
+	os.Setenv("WATCHER_DAEMON_EXCLUDED", "")
+	os.Setenv("WATCHER_DAEMON_COMMAND", "printenv WATCHER_RUN_ID")
+	os.Setenv("WATCHER_DAEMON_LOG_FORMAT", "json")
+	os.Setenv("WATCHER_DAEMON_LOG_LEVEL", "debug")
+	os.Setenv("WATCHER_DAEMON_REPORTS_DIR", "/dev/null/reports")
+	os.Setenv("WATCHER_DAEMON_STATE_DIR", t.TempDir())
+	defer os.Unsetenv("WATCHER_DAEMON_COMMAND")
+	defer os.Unsetenv("WATCHER_DAEMON_LOG_FORMAT")
+	defer os.Unsetenv("WATCHER_DAEMON_LOG_LEVEL")
+	defer os.Unsetenv("WATCHER_DAEMON_REPORTS_DIR")
+	defer os.Unsetenv("WATCHER_DAEMON_STATE_DIR")
+
+	d, err := daemon.New()
+	require.Nil(t, err, "daemon creation failure")
+
+	ctx := context.Background()
+	_, _, err = d.Scan(ctx)
+	require.Nil(t, err)
+	// writing the reports fails, which is logged with the run ID
+	d.RunCommand(ctx, nil)
+	os.Stdout = stdout
+	w.Close()
+
+	var output []string
+	var logs []map[string]interface{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "{") {
+			output = append(output, line)
+			continue
+		}
+		var entry map[string]interface{}
+		require.Nil(t, json.Unmarshal([]byte(line), &entry), line)
+		logs = append(logs, entry)
+	}
+
+	require.Equal(t, []string{"1"}, output, "the command must get the run ID")
+	require.NotEmpty(t, logs)
+	var runLogged bool
+	for _, entry := range logs {
+		require.Equal(t, "watcher-daemon", entry["service"])
+		require.Equal(t, "fixtures/basepath", entry["base_dir"])
+		if entry["msg"] == "cannot write reports of the run: cannot create reports directory /dev/null/reports: mkdir /dev/null: not a directory" {
+			require.Equal(t, float64(1), entry["run_id"])
+			runLogged = true
+		}
+	}
+	require.True(t, runLogged, "the run must be logged with its ID: %v", logs)
+}
+
+func TestDaemon_LogLevel(t *testing.T) {
+	os.Setenv("WATCHER_DAEMON_LOG_LEVEL", "loud")
+	defer os.Unsetenv("WATCHER_DAEMON_LOG_LEVEL")
+
+	_, err := daemon.New()
+	require.EqualError(t, err, `error parsing the log level: not a valid logrus Level: "loud"`)
+
+	os.Setenv("WATCHER_DAEMON_LOG_LEVEL", "")
+	os.Setenv("WATCHER_DAEMON_LOG_FORMAT", "xml")
+	defer os.Unsetenv("WATCHER_DAEMON_LOG_FORMAT")
+
+	_, err = daemon.New()
+	require.EqualError(t, err, `unknown log format "xml"`)
+}
+
+// recordingLogger keeps the logged lines with their fields.
+type recordingLogger struct {
+	mux    *sync.Mutex
+	fields string
+	lines  *[]string
+}
+
+func newRecordingLogger() recordingLogger {
+	return recordingLogger{mux: &sync.Mutex{}, lines: &[]string{}}
+}
+
+func (l recordingLogger) logf(level, format string, args ...interface{}) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	*l.lines = append(*l.lines, level+l.fields+" "+fmt.Sprintf(format, args...))
+}
+
+func (l recordingLogger) Debugf(format string, args ...interface{}) { l.logf("debug", format, args...) }
+func (l recordingLogger) Infof(format string, args ...interface{})  { l.logf("info", format, args...) }
+func (l recordingLogger) Warnf(format string, args ...interface{})  { l.logf("warn", format, args...) }
+func (l recordingLogger) Errorf(format string, args ...interface{}) { l.logf("error", format, args...) }
+
+func (l recordingLogger) WithField(key string, value interface{}) daemon.Logger {
+	l.fields += fmt.Sprintf(" %s=%v", key, value)
+	return l
+}
+
+func (l recordingLogger) Lines() []string {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return append([]string(nil), *l.lines...)
+}
+
+func TestDaemon_WithLogger(t *testing.T) {
+	reports := t.TempDir()
+	os.Setenv("WATCHER_DAEMON_BASE_PATH", "fixtures/basepath")
+	os.Setenv("WATCHER_DAEMON_EXTENSION", ".go")
+	os.Setenv("WATCHER_DAEMON_EXCLUDED", "")
+	os.Setenv("WATCHER_DAEMON_COMMAND", "ls daemon.go /nonexistent-watcher-daemon")
+	os.Setenv("WATCHER_DAEMON_HOOK_ON_FAILURE", "false")
+	os.Setenv("WATCHER_DAEMON_REPORTS_DIR", reports)
+	os.Setenv("WATCHER_DAEMON_RUN_LOGS", "true")
+	os.Setenv("WATCHER_DAEMON_STATE_DIR", t.TempDir())
+	defer os.Unsetenv("WATCHER_DAEMON_COMMAND")
+	defer os.Unsetenv("WATCHER_DAEMON_HOOK_ON_FAILURE")
+	defer os.Unsetenv("WATCHER_DAEMON_REPORTS_DIR")
+	defer os.Unsetenv("WATCHER_DAEMON_RUN_LOGS")
+	defer os.Unsetenv("WATCHER_DAEMON_STATE_DIR")
+
+	logger := newRecordingLogger()
+	var stdout, stderr bytes.Buffer
+	d, err := daemon.New(daemon.WithLogger(logger), daemon.WithStdout(&stdout), daemon.WithStderr(&stderr))
+	require.Nil(t, err, "daemon creation failure")
+
+	run := d.RunCommand(context.Background(), nil)
+	require.False(t, run.Succeeded())
+
+	require.Equal(t, "daemon.go\n", stdout.String())
+	require.Contains(t, stderr.String(), "/nonexistent-watcher-daemon")
+	require.Contains(t, logger.Lines(), "warn run_id=1 on_failure hook failed: exit status 1")
+
+	logs, err := filepath.Glob(filepath.Join(reports, "run-*-000001.log"))
+	require.Nil(t, err)
+	require.Len(t, logs, 1)
+	log, err := ioutil.ReadFile(logs[0])
+	require.Nil(t, err)
+	require.Contains(t, string(log), "daemon.go\n")
+	require.Contains(t, string(log), "/nonexistent-watcher-daemon")
+}
+
+func TestDaemon_LogsNotInStdout(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	var stdout bytes.Buffer
+	d, err := daemon.NewWithEnvironment(map[string]string{
+		"WATCHER_DAEMON_COMMAND": "echo hello",
+	}, daemon.WithStateDir(""), daemon.WithBasePath(dir), daemon.WithStdout(&stdout))
+	require.Nil(t, err, "daemon creation failure")
+
+	ctx := context.Background()
+	_, _, err = d.Scan(ctx)
+	require.Nil(t, err)
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "a.go"), []byte("package a\n"), 0644))
+	changes, _, err := d.Scan(ctx)
+	require.Nil(t, err)
+	run := d.RunCommand(ctx, changes)
+	require.True(t, run.Succeeded(), run.Error)
+
+	// the writer gets the command output only, the default logger logs
+	// the change to os.Stdout
+	require.Equal(t, "hello\n", stdout.String())
+}
+
+func TestDaemon_RunLogs_TestSummary(t *testing.T) {
+	t.Parallel()
+
+	reports := t.TempDir()
+	var stdout bytes.Buffer
+	d, err := daemon.NewWithEnvironment(map[string]string{
+		"WATCHER_DAEMON_COMMAND":      "go test ./fixtures/basepath",
+		"WATCHER_DAEMON_GO_MODE":      "true",
+		"WATCHER_DAEMON_TEST_SUMMARY": "true",
+		"WATCHER_DAEMON_REPORTS_DIR":  reports,
+		"WATCHER_DAEMON_RUN_LOGS":     "true",
+	}, daemon.WithStateDir(""), daemon.WithStdout(&stdout))
+	require.Nil(t, err, "daemon creation failure")
+
+	events, unsubscribe := d.Events()
+	defer unsubscribe()
+
+	run := d.RunCommand(context.Background(), nil)
+	require.True(t, run.Succeeded(), run.Error)
+	require.NotNil(t, run.Summary)
+
+	// the test events are summarised, only the text printed by go test
+	// reaches the output of the run, the run log, the writer and the events
+	require.NotContains(t, run.Output, `"Action"`)
+	require.Contains(t, run.Output, "[no test files]")
+	require.Contains(t, run.Output, run.Summary.String())
+	require.NotContains(t, stdout.String(), `"Action"`)
+	require.Contains(t, stdout.String(), "[no test files]")
+	logs, err := filepath.Glob(filepath.Join(reports, "run-*-000001.log"))
+	require.Nil(t, err)
+	require.Len(t, logs, 1)
+	log, err := ioutil.ReadFile(logs[0])
+	require.Nil(t, err)
+	require.NotContains(t, string(log), `"Action"`)
+	require.Contains(t, string(log), "[no test files]")
+
+	outputLines := 0
+	for len(events) != 0 {
+		if ev := <-events; ev.Type == daemon.EventRunOutput {
+			outputLines++
+		}
+	}
+	require.NotZero(t, outputLines)
+}
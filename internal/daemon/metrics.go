@@ -0,0 +1,217 @@
+package daemon
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+	scanBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+	runBuckets  = []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+)
+
+// metrics collects statistics of the daemon, exposed in the Prometheus
+// text format.
+type metrics struct {
+	scans        *counter
+	scanDuration *histogram
+	filesWatched *gauge
+	changes      *counter
+	runs         *counter
+	runDuration  *histogram
+	walkErrors   *counter
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		scans: newCounter("watcher_daemon_scans_total",
+			"Number of scans of the watched files."),
+		scanDuration: newHistogram("watcher_daemon_scan_duration_seconds",
+			"Duration of scans of the watched files.", scanBuckets),
+		filesWatched: &gauge{name: "watcher_daemon_files_watched",
+			help: "Number of files found by the last scan."},
+		changes: newCounter("watcher_daemon_changes_total",
+			"Number of detected file changes by the type of change.", "op"),
+		runs: newCounter("watcher_daemon_runs_total",
+			"Number of command runs by the outcome and exit code.", "status", "exit_code"),
+		runDuration: newHistogram("watcher_daemon_run_duration_seconds",
+			"Duration of command runs.", runBuckets),
+		walkErrors: newCounter("watcher_daemon_walk_errors_total",
+			"Number of errors walking the watched directory tree."),
+	}
+}
+
+// WriteTo writes all metrics in the Prometheus text format.
+func (m *metrics) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	m.scans.write(&b)
+	m.scanDuration.write(&b)
+	m.filesWatched.write(&b)
+	m.changes.write(&b)
+	m.runs.write(&b)
+	m.runDuration.write(&b)
+	m.walkErrors.write(&b)
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// MetricsHandler serves the metrics in the Prometheus text format.
+func (d *Daemon) MetricsHandler() http.Handler {
+	return get(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		// the status has already been sent, so a write error cannot be reported
+		_, _ = d.metrics.WriteTo(w)
+	})
+}
+
+// counter is a monotonically increasing value, optionally split by labels.
+type counter struct {
+	mux    sync.Mutex
+	name   string
+	help   string
+	labels []string
+	values map[string]float64 // keyed by the formatted label values
+}
+
+func newCounter(name, help string, labels ...string) *counter {
+	return &counter{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: map[string]float64{},
+	}
+}
+
+// Add increases the counter for the label values given in the order
+// of the counter labels.
+func (c *counter) Add(v float64, labelValues ...string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.values[formatLabels(c.labels, labelValues)] += v
+}
+
+// Inc increases the counter by one.
+func (c *counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *counter) write(b *strings.Builder) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	writeHeader(b, c.name, c.help, "counter")
+	if len(c.labels) == 0 {
+		fmt.Fprintf(b, "%s %s\n", c.name, formatValue(c.values[""]))
+		return
+	}
+
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(b, "%s{%s} %s\n", c.name, k, formatValue(c.values[k]))
+	}
+}
+
+// gauge is a value that can go up and down.
+type gauge struct {
+	mux   sync.Mutex
+	name  string
+	help  string
+	value float64
+}
+
+// Set sets the gauge to the value.
+func (g *gauge) Set(v float64) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	g.value = v
+}
+
+func (g *gauge) write(b *strings.Builder) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	writeHeader(b, g.name, g.help, "gauge")
+	fmt.Fprintf(b, "%s %s\n", g.name, formatValue(g.value))
+}
+
+// histogram counts observed values in cumulative buckets.
+type histogram struct {
+	mux     sync.Mutex
+	name    string
+	help    string
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(name, help string, buckets []float64) *histogram {
+	return &histogram{
+		name:    name,
+		help:    help,
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+// Observe adds the value to the histogram.
+func (h *histogram) Observe(v float64) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) write(b *strings.Builder) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	writeHeader(b, h.name, h.help, "histogram")
+	for i, upper := range h.buckets {
+		fmt.Fprintf(b, "%s_bucket{le=\"%s\"} %d\n", h.name, formatValue(upper), h.counts[i])
+	}
+	fmt.Fprintf(b, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+	fmt.Fprintf(b, "%s_sum %s\n", h.name, formatValue(h.sum))
+	fmt.Fprintf(b, "%s_count %d\n", h.name, h.count)
+}
+
+func writeHeader(b *strings.Builder, name, help, typ string) {
+	fmt.Fprintf(b, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(b, "# TYPE %s %s\n", name, typ)
+}
+
+func formatLabels(names, values []string) string {
+	pairs := make([]string, 0, len(names))
+	for i, name := range names {
+		v := ""
+		if i < len(values) {
+			v = values[i]
+		}
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", name, labelEscaper.Replace(v)))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
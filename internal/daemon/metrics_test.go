@@ -0,0 +1,73 @@
+// +build unit_tests
+
+package daemon_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tamarakaufler/watcher-daemon/internal/daemon"
+)
+
+func TestDaemon_MetricsHandler(t *testing.T) {
+	base := t.TempDir()
+	require.Nil(t, ioutil.WriteFile(filepath.Join(base, "a.go"), []byte("package a\n"), 0600))
+	require.Nil(t, ioutil.WriteFile(filepath.Join(base, "b.go"), []byte("package a\n"), 0600))
+
+	os.Setenv("WATCHER_DAEMON_BASE_PATH", base)
+	os.Setenv("WATCHER_DAEMON_EXTENSION", ".go")
+	os.Setenv("WATCHER_DAEMON_EXCLUDED", "")
+	os.Setenv("WATCHER_DAEMON_COMMAND", "false")
+	os.Setenv("WATCHER_DAEMON_STATE_DIR", t.TempDir())
+	defer os.Unsetenv("WATCHER_DAEMON_COMMAND")
+	defer os.Unsetenv("WATCHER_DAEMON_STATE_DIR")
+
+	d, err := daemon.New()
+	require.Nil(t, err, "daemon creation failure")
+
+	ctx := context.Background()
+	_, _, err = d.Scan(ctx)
+	require.Nil(t, err)
+
+	require.Nil(t, os.Remove(filepath.Join(base, "a.go")))
+	require.Nil(t, ioutil.WriteFile(filepath.Join(base, "c.go"), []byte("package a\n"), 0600))
+	changes, _, err := d.Scan(ctx)
+	require.Nil(t, err)
+	d.RunCommand(ctx, changes)
+	d.RunCommand(ctx, changes)
+
+	srv := httptest.NewServer(d.APIHandler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/metrics")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/plain; version=0.0.4", resp.Header.Get("Content-Type"))
+
+	body, err := ioutil.ReadAll(resp.Body)
+	require.Nil(t, err)
+	got := string(body)
+
+	for _, want := range []string{
+		"# TYPE watcher_daemon_scans_total counter\nwatcher_daemon_scans_total 2\n",
+		"# TYPE watcher_daemon_scan_duration_seconds histogram\n",
+		"watcher_daemon_scan_duration_seconds_bucket{le=\"+Inf\"} 2\n",
+		"watcher_daemon_scan_duration_seconds_count 2\n",
+		"# TYPE watcher_daemon_files_watched gauge\nwatcher_daemon_files_watched 2\n",
+		"watcher_daemon_changes_total{op=\"created\"} 1\n",
+		"watcher_daemon_changes_total{op=\"removed\"} 1\n",
+		"watcher_daemon_runs_total{status=\"failure\",exit_code=\"1\"} 2\n",
+		"watcher_daemon_run_duration_seconds_count 2\n",
+		"watcher_daemon_walk_errors_total 0\n",
+	} {
+		require.Contains(t, got, want)
+	}
+	require.NotContains(t, got, "op=\"modified\"")
+}
@@ -0,0 +1,156 @@
+package daemon
+
+import (
+	"io"
+	"time"
+)
+
+// Option configures the daemon beyond the environment variables,
+// eg when it is embedded in another tool.
+type Option func(*Daemon)
+
+// WithLogger makes the daemon log with the logger instead of logging
+// to the standard output. LogLevel and LogFormat do not apply then.
+func WithLogger(l Logger) Option {
+	return func(d *Daemon) {
+		d.logger = l
+	}
+}
+
+// WithStdout makes the command, the service and the hooks write their
+// standard output to w instead of os.Stdout.
+func WithStdout(w io.Writer) Option {
+	return func(d *Daemon) {
+		d.stdout = w
+	}
+}
+
+// WithStderr makes the command, the service and the hooks write their
+// standard error to w instead of os.Stderr.
+func WithStderr(w io.Writer) Option {
+	return func(d *Daemon) {
+		d.stderr = w
+	}
+}
+
+// WithBasePath sets the directory, whose files are watched.
+func WithBasePath(path string) Option {
+	return func(d *Daemon) {
+		d.BasePath = path
+	}
+}
+
+// WithInclude watches only the files, whose names match any of the patterns
+// (eg *.go), instead of the files with the configured extension.
+func WithInclude(patterns ...string) Option {
+	return func(d *Daemon) {
+		d.include = patterns
+	}
+}
+
+// WithExclude excludes the files matching any of the patterns, see Excluded.
+func WithExclude(patterns ...string) Option {
+	return func(d *Daemon) {
+		d.excluded = patterns
+	}
+}
+
+// WithInterval sets how often the files are checked for changes.
+func WithInterval(interval time.Duration) Option {
+	return func(d *Daemon) {
+		d.frequency = interval
+	}
+}
+
+// WithFileSystem makes the daemon walk and read the base path in the file
+// system instead of the file system of the operating system.
+func WithFileSystem(fs FileSystem) Option {
+	return func(d *Daemon) {
+		d.fs = fs
+	}
+}
+
+// WithClock makes the daemon take the time from the clock instead of
+// the system clock, eg a FakeClock in tests.
+func WithClock(c Clock) Option {
+	return func(d *Daemon) {
+		d.clock = c
+	}
+}
+
+// WithRoots watches the directories or single files in addition to the base
+// path, see Roots.
+func WithRoots(roots ...Root) Option {
+	return func(d *Daemon) {
+		d.roots = roots
+	}
+}
+
+// WithFollowSymlinks makes the daemon watch the files behind symlinks,
+// see FollowSymlinks.
+func WithFollowSymlinks(follow bool) Option {
+	return func(d *Daemon) {
+		d.FollowSymlinks = follow
+	}
+}
+
+// WithIdleInterval enables adaptive polling: the interval doubles after each
+// check without changes, up to the idle interval, see IdleFrequency.
+func WithIdleInterval(interval time.Duration) Option {
+	return func(d *Daemon) {
+		d.idleFrequency = interval
+	}
+}
+
+// WithJitter randomly varies each interval by up to the fraction of it.
+func WithJitter(fraction float64) Option {
+	return func(d *Daemon) {
+		d.Jitter = fraction
+	}
+}
+
+// WithBackend makes the daemon get the watched files from the backend.
+func WithBackend(b Backend) Option {
+	return func(d *Daemon) {
+		d.backend = b
+	}
+}
+
+// WithHandler makes the daemon handle the changes with the handler instead
+// of running the command.
+func WithHandler(h Handler) Option {
+	return func(d *Daemon) {
+		d.handler = h
+	}
+}
+
+// WithCommand sets the command run for the changes.
+func WithCommand(command string) Option {
+	return func(d *Daemon) {
+		d.Command = command
+	}
+}
+
+// WithStateDir sets the directory keeping the state of the daemon,
+// the state is not kept if empty.
+func WithStateDir(dir string) Option {
+	return func(d *Daemon) {
+		d.StateDir = dir
+	}
+}
+
+// WithSubscriptionBuffer sets the number of change batches buffered for each
+// subscriber.
+func WithSubscriptionBuffer(size int) Option {
+	return func(d *Daemon) {
+		d.subscriptionBuffer = size
+	}
+}
+
+// WithSubscriptionPolicy sets what happens to a change batch when the buffer
+// of a subscriber is full.
+func WithSubscriptionPolicy(p Policy) Option {
+	return func(d *Daemon) {
+		d.subscriptionPolicy = p
+	}
+}
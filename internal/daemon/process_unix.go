@@ -0,0 +1,25 @@
+//go:build !windows
+// +build !windows
+
+package daemon
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// newProcessGroup makes the command the leader of a new process group,
+// so that its children are stopped with it.
+func newProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// terminateProcessGroup asks the process group of the command to exit.
+func terminateProcessGroup(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+}
+
+// killProcessGroup kills the process group of the command.
+func killProcessGroup(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,19 @@
+package daemon
+
+import (
+	"os/exec"
+)
+
+// newProcessGroup does nothing, process groups are not supported.
+func newProcessGroup(cmd *exec.Cmd) {}
+
+// terminateProcessGroup kills the process of the command, as it cannot
+// be asked to exit.
+func terminateProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+// killProcessGroup kills the process of the command.
+func killProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
@@ -0,0 +1,175 @@
+package daemon
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var proxyErrorPage = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>watcher-daemon: {{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+pre { background: #1e1e1e; color: #eee; padding: 1em; overflow: auto; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.Reason}}</p>
+{{if .Output}}<pre>{{.Output}}</pre>{{end}}
+</body>
+</html>
+`))
+
+// proxyFailure describes why the service is not available.
+type proxyFailure struct {
+	Title  string
+	Reason string
+	Output string
+}
+
+// proxyGate holds requests to the service while it is being restarted.
+type proxyGate struct {
+	mux     sync.Mutex
+	ready   chan struct{} // closed when the service is running or failed
+	failure *proxyFailure
+}
+
+func newProxyGate() *proxyGate {
+	// the service is not running before the first run of the command
+	return &proxyGate{ready: make(chan struct{})}
+}
+
+// hold makes the requests wait until the service is running again.
+func (g *proxyGate) hold() {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	select {
+	case <-g.ready:
+		g.ready = make(chan struct{})
+	default:
+	}
+	g.failure = nil
+}
+
+// open lets the requests through to the running service.
+func (g *proxyGate) open() {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	g.failure = nil
+	g.release()
+}
+
+// fail answers the requests with an error page until the next restart.
+func (g *proxyGate) fail(title, reason, output string) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	g.failure = &proxyFailure{Title: title, Reason: reason, Output: output}
+	g.release()
+}
+
+func (g *proxyGate) release() {
+	select {
+	case <-g.ready:
+	default:
+		close(g.ready)
+	}
+}
+
+// wait waits until the service is running or failed and provides
+// the failure if it failed.
+func (g *proxyGate) wait(ctx context.Context, clock Clock, timeout time.Duration) (*proxyFailure, error) {
+	g.mux.Lock()
+	ready := g.ready
+	g.mux.Unlock()
+
+	select {
+	case <-ready:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-clock.After(timeout):
+		return nil, errors.Errorf("the service is not running after %s", timeout)
+	}
+
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	return g.failure, nil
+}
+
+// ProxyHandler forwards requests to the service. While the service is being
+// restarted, the requests are held, and when the command failed, they are
+// answered with an error page showing the command output.
+func (d *Daemon) ProxyHandler() (http.Handler, error) {
+	target, err := url.Parse(d.ProxyTarget)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing the proxy target %s", d.ProxyTarget)
+	}
+
+	rp := httputil.NewSingleHostReverseProxy(target)
+	rp.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		writeProxyError(w, http.StatusBadGateway, &proxyFailure{
+			Title:  "The service is not available",
+			Reason: err.Error(),
+		})
+	}
+	timeout := time.Duration(d.ProxyTimeout) * time.Second
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failure, err := d.proxy.wait(r.Context(), d.clock, timeout)
+		if err != nil {
+			writeProxyError(w, http.StatusServiceUnavailable, &proxyFailure{
+				Title:  "The service is not available",
+				Reason: err.Error(),
+			})
+			return
+		}
+		if failure != nil {
+			writeProxyError(w, http.StatusBadGateway, failure)
+			return
+		}
+		rp.ServeHTTP(w, r)
+	}), nil
+}
+
+func writeProxyError(w http.ResponseWriter, status int, failure *proxyFailure) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(status)
+	proxyErrorPage.Execute(w, failure)
+}
+
+// ServeProxy serves the reverse proxy to the service on the configured
+// address until the context is done.
+func (d *Daemon) ServeProxy(ctx context.Context) error {
+	handler, err := d.ProxyHandler()
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{
+		Addr:    d.ProxyAddr,
+		Handler: handler,
+	}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	d.logger.Infof("serving the proxy to %s on %s", d.ProxyTarget, d.ProxyAddr)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return errors.Wrapf(err, "cannot serve the proxy on %s", d.ProxyAddr)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+// +build unit_tests
+
+package daemon_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tamarakaufler/watcher-daemon/internal/daemon"
+)
+
+func newProxyDaemon(t *testing.T, command, target string) *daemon.Daemon {
+	t.Helper()
+
+	d, err := daemon.NewWithEnvironment(map[string]string{
+		"WATCHER_DAEMON_BASE_PATH":     "fixtures/basepath",
+		"WATCHER_DAEMON_EXTENSION":     ".go",
+		"WATCHER_DAEMON_COMMAND":       command,
+		"WATCHER_DAEMON_SERVICE":       "sleep 60",
+		"WATCHER_DAEMON_PROXY_TARGET":  target,
+		"WATCHER_DAEMON_PROXY_TIMEOUT": "5",
+		"WATCHER_DAEMON_STATE_DIR":     t.TempDir(),
+	})
+	require.Nil(t, err, "daemon creation failure")
+	return d
+}
+
+type proxyResponse struct {
+	status int
+	body   string
+	err    error
+}
+
+func getAsync(url string) <-chan proxyResponse {
+	ch := make(chan proxyResponse, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			ch <- proxyResponse{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		ch <- proxyResponse{status: resp.StatusCode, body: string(body), err: err}
+	}()
+	return ch
+}
+
+func TestDaemon_ProxyHandler(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "hello from %s", r.URL.Path)
+	}))
+	defer backend.Close()
+
+	d := newProxyDaemon(t, "true", backend.URL)
+	defer d.StopService()
+
+	handler, err := d.ProxyHandler()
+	require.Nil(t, err)
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	// requests are held until the service runs
+	respCh := getAsync(srv.URL + "/page")
+	select {
+	case resp := <-respCh:
+		t.Fatalf("request answered before the service started: %+v", resp)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	run := d.Restart(context.Background(), nil)
+	require.True(t, run.Succeeded())
+
+	resp := <-respCh
+	require.Nil(t, resp.err)
+	require.Equal(t, http.StatusOK, resp.status)
+	require.Equal(t, "hello from /page", resp.body)
+}
+
+func TestDaemon_ProxyHandler_CommandFailed(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hello")
+	}))
+	defer backend.Close()
+
+	d := newProxyDaemon(t, "ls /nonexistent-watcher-daemon", backend.URL)
+	defer d.StopService()
+
+	handler, err := d.ProxyHandler()
+	require.Nil(t, err)
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	run := d.Restart(context.Background(), nil)
+	require.False(t, run.Succeeded())
+
+	resp := <-getAsync(srv.URL)
+	require.Nil(t, resp.err)
+	require.Equal(t, http.StatusBadGateway, resp.status)
+	require.Contains(t, resp.body, "The command failed")
+	require.Contains(t, resp.body, "/nonexistent-watcher-daemon")
+}
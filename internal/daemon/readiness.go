@@ -0,0 +1,122 @@
+package daemon
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// States of the service recorded with the run in restart mode.
+const (
+	ServiceReady      = "ready"
+	ServiceNotReady   = "failed to become ready"
+	ServiceNotStarted = "failed to start"
+)
+
+// readyPollInterval is how often the TCP and HTTP probes are retried.
+const readyPollInterval = 100 * time.Millisecond
+
+// waitReady waits until all configured readiness probes of the service pass.
+// The service is ready as soon as it started when no probe is configured.
+func (d *Daemon) waitReady(ctx context.Context, s *service) error {
+	timeout := time.Duration(d.ReadyTimeout) * time.Second
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// the timeout is taken from the clock of the daemon
+	timedOut := make(chan struct{})
+	go func() {
+		select {
+		case <-d.clock.After(timeout):
+			close(timedOut)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	var err error
+	if d.readyLog != nil {
+		err = waitReadyLog(ctx, s)
+	}
+	if err == nil && d.ReadyTCP != "" {
+		err = d.poll(ctx, s, func() error {
+			conn, err := net.DialTimeout("tcp", d.ReadyTCP, readyPollInterval)
+			if err != nil {
+				return err
+			}
+			return conn.Close()
+		})
+	}
+	if err == nil && d.ReadyHTTP != "" {
+		err = d.poll(ctx, s, func() error {
+			return probeHTTP(ctx, d.ReadyHTTP)
+		})
+	}
+
+	if err == nil {
+		return nil
+	}
+	select {
+	case <-timedOut:
+		return errors.Errorf("the service is not ready after %s", timeout)
+	default:
+		return err
+	}
+}
+
+func waitReadyLog(ctx context.Context, s *service) error {
+	select {
+	case <-s.logReady:
+		return nil
+	case <-s.done:
+		// the service may exit right after logging the line
+		select {
+		case <-s.logReady:
+			return nil
+		default:
+		}
+		return errors.New("the service exited")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// poll retries the probe until it passes, the service exits or the context
+// is done.
+func (d *Daemon) poll(ctx context.Context, s *service, probe func() error) error {
+	tick := d.clock.NewTicker(readyPollInterval)
+	defer tick.Stop()
+
+	for {
+		if err := probe(); err == nil {
+			return nil
+		}
+		select {
+		case <-s.done:
+			return errors.New("the service exited")
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-tick.C():
+		}
+	}
+}
+
+func probeHTTP(ctx context.Context, url string) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf("GET %s returned %s", url, resp.Status)
+	}
+	return nil
+}
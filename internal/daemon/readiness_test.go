@@ -0,0 +1,125 @@
+// +build unit_tests
+
+package daemon_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tamarakaufler/watcher-daemon/internal/daemon"
+)
+
+func TestDaemon_Restart_Readiness(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer l.Close()
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	closedAddr := closed.Addr().String()
+	closed.Close()
+
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer healthy.Close()
+	unhealthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unhealthy.Close()
+
+	tests := []struct {
+		name    string
+		service string
+		env     map[string]string
+		want    string
+		wantErr string
+	}{
+		{
+			name:    "no probe",
+			service: "sleep 60",
+			want:    daemon.ServiceReady,
+		},
+		{
+			name:    "TCP port open",
+			service: "sleep 60",
+			env:     map[string]string{"WATCHER_DAEMON_READY_TCP": l.Addr().String()},
+			want:    daemon.ServiceReady,
+		},
+		{
+			name:    "TCP port closed",
+			service: "sleep 60",
+			env:     map[string]string{"WATCHER_DAEMON_READY_TCP": closedAddr},
+			want:    daemon.ServiceNotReady,
+			wantErr: "the service is not ready after 1s",
+		},
+		{
+			name:    "HTTP 2xx",
+			service: "sleep 60",
+			env:     map[string]string{"WATCHER_DAEMON_READY_HTTP": healthy.URL},
+			want:    daemon.ServiceReady,
+		},
+		{
+			name:    "HTTP 503",
+			service: "sleep 60",
+			env:     map[string]string{"WATCHER_DAEMON_READY_HTTP": unhealthy.URL},
+			want:    daemon.ServiceNotReady,
+			wantErr: "the service is not ready after 1s",
+		},
+		{
+			name:    "log line matched",
+			service: "echo server listening on :8080",
+			env:     map[string]string{"WATCHER_DAEMON_READY_LOG": "listening on :\\d+"},
+			want:    daemon.ServiceReady,
+		},
+		{
+			name:    "service exited before logging the line",
+			service: "echo starting",
+			env:     map[string]string{"WATCHER_DAEMON_READY_LOG": "listening on :\\d+"},
+			want:    daemon.ServiceNotReady,
+			wantErr: "the service exited",
+		},
+		{
+			name:    "service failed to start",
+			service: "/nonexistent-watcher-daemon",
+			want:    daemon.ServiceNotStarted,
+			wantErr: "error starting the service",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv("WATCHER_DAEMON_BASE_PATH", "fixtures/basepath")
+			os.Setenv("WATCHER_DAEMON_EXTENSION", ".go")
+			os.Setenv("WATCHER_DAEMON_EXCLUDED", "")
+			os.Setenv("WATCHER_DAEMON_COMMAND", "true")
+			os.Setenv("WATCHER_DAEMON_SERVICE", tt.service)
+			os.Setenv("WATCHER_DAEMON_READY_TIMEOUT", "1")
+			os.Setenv("WATCHER_DAEMON_STATE_DIR", t.TempDir())
+			for k, v := range tt.env {
+				os.Setenv(k, v)
+				defer os.Unsetenv(k)
+			}
+			defer os.Unsetenv("WATCHER_DAEMON_COMMAND")
+			defer os.Unsetenv("WATCHER_DAEMON_SERVICE")
+			defer os.Unsetenv("WATCHER_DAEMON_READY_TIMEOUT")
+			defer os.Unsetenv("WATCHER_DAEMON_STATE_DIR")
+
+			d, err := daemon.New()
+			require.Nil(t, err, "daemon creation failure")
+			defer d.StopService()
+
+			run := d.Restart(context.Background(), nil)
+			require.Equal(t, tt.want, run.Service)
+			if tt.wantErr == "" {
+				require.True(t, run.Succeeded(), run.Error)
+				return
+			}
+			require.False(t, run.Succeeded())
+			require.Contains(t, run.Error, tt.wantErr)
+			require.Equal(t, run, d.Runs()[0], "the run must be recorded once ready or failed")
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package daemon
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const reportPrefix = "run-"
+
+// junitTestSuites is the root element of a JUnit XML report.
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Content string `xml:",chardata"`
+}
+
+// reportName is the name of the report files of the run, without extension.
+func reportName(run Run) string {
+	return fmt.Sprintf("%s%s-%06d", reportPrefix, run.Start.UTC().Format("20060102T150405"), run.ID)
+}
+
+// createRunLog creates the log file of the run in the reports directory,
+// pruned together with the reports of the run.
+func (d *Daemon) createRunLog(run Run) (*os.File, error) {
+	if err := os.MkdirAll(d.ReportsDir, 0755); err != nil {
+		return nil, errors.Wrapf(err, "cannot create reports directory %s", d.ReportsDir)
+	}
+	f, err := os.Create(filepath.Join(d.ReportsDir, reportName(run)+".log"))
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot create the run log")
+	}
+	return f, nil
+}
+
+// WriteReports writes a JSON report of the run, and a JUnit XML report
+// if test results are available, into the reports directory. Reports
+// of the oldest runs are removed beyond the configured retention.
+func (d *Daemon) WriteReports(run Run) error {
+	if d.ReportsDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(d.ReportsDir, 0755); err != nil {
+		return errors.Wrapf(err, "cannot create reports directory %s", d.ReportsDir)
+	}
+
+	name := reportName(run)
+
+	data, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "cannot encode JSON report")
+	}
+	if err := ioutil.WriteFile(filepath.Join(d.ReportsDir, name+".json"), data, 0644); err != nil {
+		return errors.Wrap(err, "cannot write JSON report")
+	}
+
+	// a run not made by this daemon, eg read from the history, keeps only
+	// the results of the failed and skipped tests
+	tests := run.testResults
+	if tests == nil && run.Summary != nil {
+		tests = run.Summary.Tests
+	}
+	if len(tests) != 0 {
+		data, err := xml.MarshalIndent(junitReport(tests), "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "cannot encode JUnit report")
+		}
+		data = append([]byte(xml.Header), data...)
+		if err := ioutil.WriteFile(filepath.Join(d.ReportsDir, name+".xml"), data, 0644); err != nil {
+			return errors.Wrap(err, "cannot write JUnit report")
+		}
+	}
+
+	return d.pruneReports()
+}
+
+// junitReport converts test results into JUnit test suites, one per package.
+func junitReport(tests []TestResult) junitTestSuites {
+	var report junitTestSuites
+	suites := map[string]int{}
+	var elapsed []time.Duration
+
+	for _, t := range tests {
+		i, ok := suites[t.Package]
+		if !ok {
+			i = len(report.Suites)
+			suites[t.Package] = i
+			report.Suites = append(report.Suites, junitTestSuite{Name: t.Package})
+			elapsed = append(elapsed, 0)
+		}
+		elapsed[i] += t.Elapsed
+		suite := &report.Suites[i]
+
+		tc := junitTestCase{
+			ClassName: t.Package,
+			Name:      t.Name,
+			Time:      fmt.Sprintf("%.3f", t.Elapsed.Seconds()),
+		}
+		switch t.Action {
+		case "fail":
+			tc.Failure = &junitMessage{Message: "Failed", Content: t.Output}
+			suite.Failures++
+		case "skip":
+			tc.Skipped = &junitMessage{Message: "Skipped", Content: t.Output}
+			suite.Skipped++
+		}
+		suite.Tests++
+		suite.Cases = append(suite.Cases, tc)
+	}
+
+	for i := range report.Suites {
+		report.Suites[i].Time = fmt.Sprintf("%.3f", elapsed[i].Seconds())
+	}
+	return report
+}
+
+// pruneReports removes reports of the oldest runs beyond the retention.
+func (d *Daemon) pruneReports() error {
+	if d.ReportsRetention <= 0 {
+		return nil
+	}
+
+	entries, err := ioutil.ReadDir(d.ReportsDir)
+	if err != nil {
+		return errors.Wrapf(err, "cannot read reports directory %s", d.ReportsDir)
+	}
+
+	runs := map[string][]string{}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, reportPrefix) {
+			continue
+		}
+		base := strings.TrimSuffix(name, filepath.Ext(name))
+		runs[base] = append(runs[base], name)
+	}
+	if len(runs) <= d.ReportsRetention {
+		return nil
+	}
+
+	bases := make([]string, 0, len(runs))
+	for base := range runs {
+		bases = append(bases, base)
+	}
+	// names start with the run timestamp, so they sort chronologically
+	sort.Strings(bases)
+
+	for _, base := range bases[:len(bases)-d.ReportsRetention] {
+		for _, name := range runs[base] {
+			if err := os.Remove(filepath.Join(d.ReportsDir, name)); err != nil {
+				return errors.Wrapf(err, "cannot remove report %s", name)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+// +build unit_tests
+
+package daemon_test
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tamarakaufler/watcher-daemon/internal/daemon"
+)
+
+func TestDaemon_WriteReports(t *testing.T) {
+	dir := t.TempDir()
+
+	os.Setenv("WATCHER_DAEMON_BASE_PATH", "fixtures/basepath")
+	os.Setenv("WATCHER_DAEMON_REPORTS_DIR", dir)
+	os.Setenv("WATCHER_DAEMON_REPORTS_RETENTION", "2")
+	defer os.Unsetenv("WATCHER_DAEMON_REPORTS_DIR")
+	defer os.Unsetenv("WATCHER_DAEMON_REPORTS_RETENTION")
+
+	d, err := daemon.New()
+	require.Nil(t, err, "daemon creation failure")
+
+	start := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	summary := &daemon.TestSummary{
+		Passed:      []string{"example.com/m/b"},
+		Failed:      []string{"example.com/m/a"},
+		FailedTests: []string{"example.com/m/a.TestA"},
+		Tests: []daemon.TestResult{
+			{Package: "example.com/m/a", Name: "TestA", Action: "fail", Elapsed: time.Second, Output: "boom\n"},
+			{Package: "example.com/m/a", Name: "TestA2", Action: "skip"},
+			{Package: "example.com/m/b", Name: "TestB", Action: "pass", Elapsed: time.Second / 2},
+		},
+	}
+	runs := []daemon.Run{
+		{ID: 1, Start: start, Command: "echo", ExitCode: 0},
+		{ID: 2, Start: start.Add(time.Minute), Command: "go test ./...", ExitCode: 1, Summary: summary},
+		{ID: 3, Start: start.Add(2 * time.Minute), Command: "echo", ExitCode: 0},
+	}
+	for _, r := range runs {
+		require.Nil(t, d.WriteReports(r))
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	require.Nil(t, err)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	require.Equal(t, []string{
+		"run-20210301T100100-000002.json",
+		"run-20210301T100100-000002.xml",
+		"run-20210301T100200-000003.json",
+	}, names, "reports of the oldest run must be removed")
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "run-20210301T100100-000002.json"))
+	require.Nil(t, err)
+	var got daemon.Run
+	require.Nil(t, json.Unmarshal(data, &got))
+	require.Equal(t, runs[1].Command, got.Command)
+	require.Equal(t, runs[1].ExitCode, got.ExitCode)
+	require.Equal(t, summary, got.Summary)
+
+	data, err = ioutil.ReadFile(filepath.Join(dir, "run-20210301T100100-000002.xml"))
+	require.Nil(t, err)
+	var junit struct {
+		Suites []struct {
+			Name     string `xml:"name,attr"`
+			Tests    int    `xml:"tests,attr"`
+			Failures int    `xml:"failures,attr"`
+			Skipped  int    `xml:"skipped,attr"`
+			Time     string `xml:"time,attr"`
+			Cases    []struct {
+				Name    string `xml:"name,attr"`
+				Failure *struct {
+					Content string `xml:",chardata"`
+				} `xml:"failure"`
+			} `xml:"testcase"`
+		} `xml:"testsuite"`
+	}
+	require.Nil(t, xml.Unmarshal(data, &junit))
+	require.Len(t, junit.Suites, 2)
+	require.Equal(t, "example.com/m/a", junit.Suites[0].Name)
+	require.Equal(t, 2, junit.Suites[0].Tests)
+	require.Equal(t, 1, junit.Suites[0].Failures)
+	require.Equal(t, 1, junit.Suites[0].Skipped)
+	require.Equal(t, "1.000", junit.Suites[0].Time)
+	require.Equal(t, "boom\n", junit.Suites[0].Cases[0].Failure.Content)
+	require.Equal(t, "example.com/m/b", junit.Suites[1].Name)
+	require.Equal(t, "0.500", junit.Suites[1].Time)
+	require.Nil(t, junit.Suites[1].Cases[0].Failure)
+}
+
+func TestDaemon_RunCommand_PassedTestsOnlyInJUnitReport(t *testing.T) {
+	t.Parallel()
+
+	reports := t.TempDir()
+	d, err := daemon.NewWithEnvironment(map[string]string{
+		"WATCHER_DAEMON_COMMAND":      "go test ./fixtures/gotests",
+		"WATCHER_DAEMON_GO_MODE":      "true",
+		"WATCHER_DAEMON_TEST_SUMMARY": "true",
+		"WATCHER_DAEMON_REPORTS_DIR":  reports,
+	}, daemon.WithStateDir(""), daemon.WithStdout(ioutil.Discard))
+	require.Nil(t, err, "daemon creation failure")
+
+	run := d.RunCommand(context.Background(), nil)
+	require.True(t, run.Succeeded(), run.Error)
+	require.NotNil(t, run.Summary)
+
+	// the passed test is reported in JUnit, but not kept with the run
+	require.Len(t, run.Summary.Tests, 1)
+	require.Equal(t, "TestSkip", run.Summary.Tests[0].Name)
+	require.Equal(t, run.Summary.Tests, d.Runs()[0].Summary.Tests)
+
+	xmls, err := filepath.Glob(filepath.Join(reports, "*.xml"))
+	require.Nil(t, err)
+	require.Len(t, xmls, 1)
+	data, err := ioutil.ReadFile(xmls[0])
+	require.Nil(t, err)
+	var junit struct {
+		Suites []struct {
+			Tests   int `xml:"tests,attr"`
+			Skipped int `xml:"skipped,attr"`
+		} `xml:"testsuite"`
+	}
+	require.Nil(t, xml.Unmarshal(data, &junit))
+	require.Len(t, junit.Suites, 1)
+	require.Equal(t, 2, junit.Suites[0].Tests)
+	require.Equal(t, 1, junit.Suites[0].Skipped)
+}
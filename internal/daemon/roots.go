@@ -0,0 +1,168 @@
+package daemon
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Root is a directory or a single file watched in addition to the base path.
+type Root struct {
+	Path string
+	// Include are file name patterns (eg *.go) of the watched files, replacing
+	// the extension and the include patterns of the daemon for the root.
+	// A single file is watched regardless of them.
+	Include []string
+	// Exclude are patterns excluding files in addition to the exclusions
+	// of the daemon, see Excluded.
+	Exclude []string
+}
+
+// parseRoots parses roots separated by semicolons. Each root is a path,
+// optionally followed by include and exclude patterns separated by commas,
+// eg ../shared?include=*.go,*.tmpl&exclude=vendor;/etc/app/config.yaml
+func parseRoots(s string) ([]Root, error) {
+	var roots []Root
+	for _, item := range strings.Split(s, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		parts := strings.SplitN(item, "?", 2)
+		path := strings.TrimSpace(parts[0])
+		if path == "" {
+			return nil, errors.Errorf("root %q has no path", item)
+		}
+		r := Root{Path: filepath.Clean(path)}
+		if len(parts) == 2 {
+			for _, opt := range strings.Split(parts[1], "&") {
+				kv := strings.SplitN(opt, "=", 2)
+				if len(kv) != 2 {
+					return nil, errors.Errorf("invalid option %q of the root %s", opt, r.Path)
+				}
+				switch kv[0] {
+				case "include":
+					r.Include = splitList(kv[1])
+				case "exclude":
+					r.Exclude = splitList(kv[1])
+				default:
+					return nil, errors.Errorf("unknown option %q of the root %s", kv[0], r.Path)
+				}
+			}
+		}
+		roots = append(roots, r)
+	}
+	return roots, nil
+}
+
+// validatePatterns checks the include and exclusion patterns of the daemon
+// and of the roots, so that they do not fail the scans.
+func (d *Daemon) validatePatterns() error {
+	if err := validateInclude(d.include); err != nil {
+		return err
+	}
+	if err := validateExcluded(d.excluded); err != nil {
+		return err
+	}
+	for _, r := range d.roots {
+		if err := validateInclude(r.Include); err != nil {
+			return errors.Wrapf(err, "root %s", r.Path)
+		}
+		if err := validateExcluded(r.Exclude); err != nil {
+			return errors.Wrapf(err, "root %s", r.Path)
+		}
+	}
+	return nil
+}
+
+// watchedRoots provides the base path, watched with the criteria
+// of the daemon, and the additional roots.
+func (d *Daemon) watchedRoots() []Root {
+	return append([]Root{{Path: d.BasePath}}, d.roots...)
+}
+
+// isExcludedFromRoot reports whether the file is excluded by the daemon
+// or by the root.
+func (d *Daemon) isExcludedFromRoot(ctx context.Context, r Root, path, name string) (bool, error) {
+	excl, err := d.IsExcluded(ctx, path, name)
+	if err != nil || excl {
+		return excl, err
+	}
+	return isExcluded(r.Exclude, path, name)
+}
+
+// isStateDir reports whether the directory is the state directory, whose
+// files are not watched.
+func (d *Daemon) isStateDir(dir string) bool {
+	return d.StateDir != "" && absPath(dir) == absPath(d.StateDir)
+}
+
+// collectRoot collects the watched files of the root. A missing root,
+// other than the base path, has no files, so that its removal is detected.
+func (d *Daemon) collectRoot(ctx context.Context, r Root, isBasePath bool) ([]FileInfo, error) {
+	info, err := d.fs.Stat(r.Path)
+	if err != nil && os.IsNotExist(err) && !isBasePath {
+		d.log(ctx).Debugf("root %s does not exist", r.Path)
+		return nil, nil
+	}
+	if err == nil && !info.IsDir() {
+		excl, err := d.isExcludedFromRoot(ctx, r, r.Path, info.Name())
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot proccess exclusion of files")
+		}
+		if excl {
+			return nil, nil
+		}
+		f := FileInfo{
+			Path:    r.Path,
+			Name:    info.Name(),
+			ModTime: info.ModTime(),
+			Size:    info.Size(),
+		}
+		if d.FollowSymlinks {
+			if target, err := d.fs.EvalSymlinks(r.Path); err == nil && target != r.Path {
+				f.Target = target
+			}
+		}
+		return []FileInfo{f}, nil
+	}
+	if d.FollowSymlinks {
+		return d.collectFollowing(ctx, r)
+	}
+
+	var files []FileInfo
+	err = d.fs.Walk(r.Path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			d.metrics.walkErrors.Inc()
+			return err
+		}
+		if info.IsDir() && d.isStateDir(path) {
+			return filepath.SkipDir
+		}
+		if info.IsDir() || strings.HasPrefix(path, ".git") {
+			return err // this will be nil if there is no problem with the file
+		}
+
+		watched, err := d.isWatchedFile(ctx, r, path, info.Name())
+		if err != nil || !watched {
+			return err
+		}
+
+		files = append(files, FileInfo{
+			Path:    path,
+			Name:    info.Name(),
+			ModTime: info.ModTime(),
+			Size:    info.Size(),
+		})
+		return nil
+	})
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "error collecting files from %s", r.Path)
+	}
+	return files, nil
+}
@@ -0,0 +1,108 @@
+// +build unit_tests
+
+package daemon_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tamarakaufler/watcher-daemon/internal/daemon"
+)
+
+func TestDaemon_Roots(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	modTime := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	fs := daemon.NewMemFileSystem()
+	for _, path := range []string{
+		"src/main.go",
+		"src/sub/util.go",
+		"src/notes.txt",
+		"shared/lib.go",
+		"shared/page.tmpl",
+		"shared/gen/gen.go",
+		"shared/README.md",
+		"/etc/app/config.yaml",
+		"/etc/app/other.yaml",
+	} {
+		fs.WriteFile(path, []byte(path), modTime)
+	}
+
+	d, err := daemon.NewWithEnvironment(map[string]string{
+		"WATCHER_DAEMON_ROOTS": "shared?include=*.go,*.tmpl&exclude=gen; /etc/app/config.yaml; ./src/sub; /etc/missing.yaml",
+	},
+		daemon.WithStateDir(""),
+		daemon.WithFileSystem(fs),
+		daemon.WithBasePath("src"),
+	)
+	require.Nil(t, err, "daemon creation failure")
+
+	files, err := d.CollectFiles(ctx)
+	require.Nil(t, err)
+	paths := []string{}
+	for _, f := range files {
+		paths = append(paths, f.Path)
+	}
+	// src/sub/util.go is in two roots
+	require.Equal(t, []string{
+		"src/main.go",
+		"src/sub/util.go",
+		"shared/lib.go",
+		"shared/page.tmpl",
+		"/etc/app/config.yaml",
+	}, paths)
+
+	_, _, err = d.Scan(ctx)
+	require.Nil(t, err)
+
+	fs.WriteFile("/etc/app/config.yaml", []byte("debug: true"), modTime.Add(time.Second))
+	fs.WriteFile("src/sub/util.go", []byte("package sub"), modTime.Add(time.Second))
+	changes, changed, err := d.Scan(ctx)
+	require.Nil(t, err)
+	require.True(t, changed)
+	require.Equal(t, daemon.ChangeSet{
+		{Path: "/etc/app/config.yaml", Name: "config.yaml", Op: daemon.OpModified},
+		{Path: "src/sub/util.go", Name: "util.go", Op: daemon.OpModified},
+	}, changes)
+
+	// a removed single file root is a change, not an error
+	require.Nil(t, fs.Remove("/etc/app/config.yaml"))
+	changes, changed, err = d.Scan(ctx)
+	require.Nil(t, err)
+	require.True(t, changed)
+	require.Equal(t, daemon.ChangeSet{
+		{Path: "/etc/app/config.yaml", Name: "config.yaml", Op: daemon.OpRemoved},
+	}, changes)
+}
+
+func TestNewWithEnvironment_InvalidRoots(t *testing.T) {
+	t.Parallel()
+
+	for _, roots := range []string{
+		"?include=*.go",
+		"shared?include",
+		"shared?only=*.go",
+	} {
+		_, err := daemon.NewWithEnvironment(map[string]string{
+			"WATCHER_DAEMON_ROOTS": roots,
+		}, daemon.WithStateDir(""))
+		require.NotNil(t, err, roots)
+	}
+}
+
+func TestNewWithEnvironment_InvalidPatterns(t *testing.T) {
+	t.Parallel()
+
+	for _, environment := range []map[string]string{
+		{"WATCHER_DAEMON_EXCLUDED": "vendor,gen(.go"},
+		{"WATCHER_DAEMON_ROOTS": "shared?exclude=gen(.go"},
+		{"WATCHER_DAEMON_ROOTS": "shared?include=[*.go"},
+	} {
+		_, err := daemon.NewWithEnvironment(environment, daemon.WithStateDir(""))
+		require.NotNil(t, err, environment)
+	}
+}
@@ -0,0 +1,269 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxRuns limits the number of runs kept in the run history in memory.
+	maxRuns = 100
+	// maxOutput limits the size of the command output kept with each run.
+	maxOutput = 16 * 1024
+)
+
+// Run captures the outcome of one run of the command.
+type Run struct {
+	ID       int           `json:"id"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	Files    []string      `json:"files"`
+	Command  string        `json:"command"`
+	ExitCode int           `json:"exit_code"`
+	Error    string        `json:"error,omitempty"`
+	Summary  *TestSummary  `json:"summary,omitempty"`
+	Output   string        `json:"output,omitempty"`  // truncated to the last part
+	Service  string        `json:"service,omitempty"` // state of the service in restart mode
+
+	// results of all tests, the passed ones included, kept for the JUnit report
+	testResults []TestResult
+}
+
+// Succeeded reports whether the command completed successfully.
+func (r Run) Succeeded() bool {
+	return r.Error == ""
+}
+
+// Runs returns the run history, the most recent run last.
+func (d *Daemon) Runs() []Run {
+	d.runsMux.Lock()
+	defer d.runsMux.Unlock()
+
+	runs := make([]Run, len(d.runs))
+	copy(runs, d.runs)
+	return runs
+}
+
+// lastRun returns the most recent run, the last one in the history if there
+// was none since the daemon started, nil if there is none at all.
+func (d *Daemon) lastRun() *Run {
+	d.runsMux.Lock()
+	defer d.runsMux.Unlock()
+
+	if len(d.runs) == 0 {
+		return d.recordedRun
+	}
+	run := d.runs[len(d.runs)-1]
+	return &run
+}
+
+// nextRunID provides ID for a new run.
+func (d *Daemon) nextRunID() int {
+	d.runsMux.Lock()
+	defer d.runsMux.Unlock()
+
+	d.lastRunID++
+	return d.lastRunID
+}
+
+func (d *Daemon) recordRun(run Run) {
+	d.runsMux.Lock()
+	defer d.runsMux.Unlock()
+
+	d.runs = append(d.runs, run)
+	if len(d.runs) > maxRuns {
+		d.runs = d.runs[len(d.runs)-maxRuns:]
+	}
+
+	if err := d.appendHistory(run); err != nil {
+		d.runLogger(run.ID).Warnf("cannot record the run in the history: %s", err)
+	}
+}
+
+// RunCommand runs the command for the detected changes and records
+// the outcome in the run history.
+func (d *Daemon) RunCommand(ctx context.Context, changes ChangeSet) Run {
+	return d.finishRun(ctx, d.runCommand(ctx, changes))
+}
+
+// runCommand runs the command without recording the outcome.
+func (d *Daemon) runCommand(ctx context.Context, changes ChangeSet) Run {
+	run := Run{
+		ID:       d.nextRunID(),
+		Start:    d.clock.Now(),
+		Files:    changes.Paths(),
+		ExitCode: -1,
+	}
+	ctx = d.withRunID(ctx, run.ID)
+	d.publish(Event{Type: EventRunStarted, RunID: run.ID})
+
+	if d.handler != nil {
+		return d.runHandler(ctx, run, changes)
+	}
+
+	cmdParts, err := d.BuildCommand(ctx, changes)
+	if err != nil {
+		run.Error = errors.Wrap(err, "error preparing the command").Error()
+		return run
+	}
+
+	summarise := d.GoMode && d.TestSummary
+	if summarise {
+		cmdParts = withTestJSON(cmdParts)
+	}
+	run.Command = strings.Join(cmdParts, " ")
+	d.runHook(ctx, HookPre, d.HookPre, run, false)
+
+	var stdout, stderr bytes.Buffer
+	output := &tailBuffer{max: maxOutput}
+	lines := &lineWriter{publish: func(line string) {
+		d.publish(Event{Type: EventRunOutput, RunID: run.ID, Line: line})
+	}}
+	outW, errW := d.stdout, d.stderr
+	if d.RunLogs {
+		f, err := d.createRunLog(run)
+		if err != nil {
+			d.log(ctx).Warnf("%s", err)
+		} else {
+			defer f.Close()
+			outW, errW = io.MultiWriter(outW, f), io.MultiWriter(errW, f)
+		}
+	}
+
+	cmd := exec.Command(cmdParts[0], cmdParts[1:]...)
+	cmd.Env = append(os.Environ(), "WATCHER_RUN_ID="+strconv.Itoa(run.ID))
+	// these can be commented out if not needed
+	cmd.Stdout = io.MultiWriter(outW, output, lines)
+	cmd.Stderr = io.MultiWriter(errW, output, lines)
+	var rendered *testOutputWriter
+	if summarise {
+		// test events are summarised, only the text printed by the tests is output
+		rendered = &testOutputWriter{w: io.MultiWriter(outW, output, lines)}
+		cmd.Stdout = io.MultiWriter(rendered, &stdout)
+		cmd.Stderr = io.MultiWriter(errW, output, lines, &stderr)
+	}
+
+	err = cmd.Run()
+	if rendered != nil {
+		_ = rendered.Flush()
+	}
+	lines.Flush()
+	run.Duration = d.clock.Since(run.Start)
+	run.ExitCode = cmd.ProcessState.ExitCode()
+	if err != nil {
+		run.Error = errors.Wrap(err, "error occurred processing during file watch").Error()
+	}
+
+	if summarise {
+		summary, err := ParseGoOutput(io.MultiReader(&stdout, &stderr))
+		if err != nil {
+			d.log(ctx).Warnf("cannot parse the command output: %s", err)
+		} else {
+			run.testResults = summary.Tests
+			summary.Tests = failedOrSkipped(summary.Tests)
+			run.Summary = summary
+			d.log(ctx).Infof("%s", summary)
+			fmt.Fprintln(output, summary)
+		}
+	}
+	run.Output = output.String()
+
+	return run
+}
+
+// Handler handles the detected changes in process, instead of the command.
+// An error fails the run.
+type Handler func(ctx context.Context, changes ChangeSet) error
+
+// runHandler runs the handler for the changes without recording the outcome.
+func (d *Daemon) runHandler(ctx context.Context, run Run, changes ChangeSet) Run {
+	d.runHook(ctx, HookPre, d.HookPre, run, false)
+
+	err := d.handler(ctx, changes)
+	run.Duration = d.clock.Since(run.Start)
+	run.ExitCode = 0
+	if err != nil {
+		run.ExitCode = 1
+		run.Error = errors.Wrap(err, "error occurred handling the changes").Error()
+	}
+	return run
+}
+
+// finishRun records the outcome of the run and runs the hooks.
+func (d *Daemon) finishRun(ctx context.Context, run Run) Run {
+	ctx = d.withRunID(ctx, run.ID)
+	status := "success"
+	if !run.Succeeded() {
+		status = "failure"
+	}
+	d.metrics.runs.Inc(status, strconv.Itoa(run.ExitCode))
+	d.metrics.runDuration.Observe(run.Duration.Seconds())
+
+	// the results of the passed tests are not kept beyond the reports
+	report := run
+	run.testResults = nil
+
+	previous := d.lastRun()
+	d.recordRun(run)
+	d.notifyWebhooks(run, previous)
+	d.runHooks(ctx, run, previous)
+	if err := d.WriteReports(report); err != nil {
+		d.runLogger(run.ID).Warnf("cannot write reports of the run: %s", err)
+	}
+
+	d.publish(Event{Type: EventRunFinished, RunID: run.ID, Run: &run})
+	return run
+}
+
+// tailBuffer keeps the last max bytes written to it.
+type tailBuffer struct {
+	mux       sync.Mutex
+	max       int
+	buf       []byte
+	truncated bool
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > b.max {
+		b.buf = b.buf[len(b.buf)-b.max:]
+		b.truncated = true
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if b.truncated {
+		return "... (truncated)\n" + string(b.buf)
+	}
+	return string(b.buf)
+}
+
+// withTestJSON makes go test emit test events for the summary.
+func withTestJSON(cmdParts []string) []string {
+	if len(cmdParts) < 2 || cmdParts[0] != "go" || cmdParts[1] != "test" {
+		return cmdParts
+	}
+	for _, p := range cmdParts[2:] {
+		if p == "-json" {
+			return cmdParts
+		}
+	}
+	return append([]string{"go", "test", "-json"}, cmdParts[2:]...)
+}
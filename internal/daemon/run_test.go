@@ -0,0 +1,70 @@
+// +build unit_tests
+
+package daemon_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tamarakaufler/watcher-daemon/internal/daemon"
+)
+
+func TestDaemon_RunCommand(t *testing.T) {
+	tests := []struct {
+		name         string
+		command      string
+		wantCommand  string
+		wantExitCode int
+		wantSuccess  bool
+	}{
+		{
+			name:         "successful command",
+			command:      "echo {{.Files}}",
+			wantCommand:  "echo fixtures/basepath/test.go",
+			wantExitCode: 0,
+			wantSuccess:  true,
+		},
+		{
+			name:         "failed command",
+			command:      "false",
+			wantCommand:  "false",
+			wantExitCode: 1,
+			wantSuccess:  false,
+		},
+		{
+			name:         "command that cannot be started",
+			command:      "./does-not-exist",
+			wantCommand:  "./does-not-exist",
+			wantExitCode: -1,
+			wantSuccess:  false,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv("WATCHER_DAEMON_BASE_PATH", "fixtures/basepath")
+			os.Setenv("WATCHER_DAEMON_COMMAND", tt.command)
+			os.Setenv("WATCHER_DAEMON_STATE_DIR", t.TempDir())
+			defer os.Unsetenv("WATCHER_DAEMON_COMMAND")
+			defer os.Unsetenv("WATCHER_DAEMON_STATE_DIR")
+
+			d, err := daemon.New()
+			require.Nil(t, err, "daemon creation failure")
+
+			changes := daemon.ChangeSet{
+				{Path: "fixtures/basepath/test.go", Name: "test.go", Op: daemon.OpModified},
+			}
+			run := d.RunCommand(context.Background(), changes)
+			require.Equal(t, tt.wantCommand, run.Command)
+			require.Equal(t, tt.wantExitCode, run.ExitCode)
+			require.Equal(t, tt.wantSuccess, run.Succeeded())
+			require.Equal(t, []string{"fixtures/basepath/test.go"}, run.Files)
+
+			runs := d.Runs()
+			require.Len(t, runs, 1)
+			require.Equal(t, 1, runs[0].ID)
+		})
+	}
+}
@@ -0,0 +1,286 @@
+package daemon
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	posType          = reflect.TypeOf(token.NoPos)
+	commentGroupType = reflect.TypeOf((*ast.CommentGroup)(nil))
+	objectType       = reflect.TypeOf((*ast.Object)(nil))
+	scopeType        = reflect.TypeOf((*ast.Scope)(nil))
+)
+
+// FilterSemanticChanges drops changes of Go files, whose code differs from
+// the previously seen one only in comments or formatting. The new code
+// is remembered for the next comparison.
+func (d *Daemon) FilterSemanticChanges(ctx context.Context, changes ChangeSet) ChangeSet {
+	d.goCodeMux.Lock()
+	defer d.goCodeMux.Unlock()
+
+	if d.goCode == nil {
+		d.goCode = map[string]string{}
+	}
+
+	var filtered ChangeSet
+	for _, c := range changes {
+		if filepath.Ext(c.Path) != ".go" {
+			filtered = append(filtered, c)
+			continue
+		}
+
+		previous, ok := d.goCode[c.Path]
+		code, err := d.readGoCode(c.Path)
+		if err != nil {
+			d.log(ctx).Debugf("cannot read %s for semantic comparison: %s", c.Path, err)
+			delete(d.goCode, c.Path)
+			filtered = append(filtered, c)
+			continue
+		}
+		d.goCode[c.Path] = code
+		if ok && previous == code {
+			d.log(ctx).Infof("File %s has not changed semantically", c.Name)
+			continue
+		}
+		filtered = append(filtered, c)
+	}
+	return filtered
+}
+
+// cacheGoCode remembers the code of the watched Go files when the cache
+// is empty, so that the first change of each file can be compared. The code
+// of files changed while the daemon was not running is the one persisted
+// with the snapshot. It is unknown if it was not persisted, and the changes
+// of such files are kept.
+func (d *Daemon) cacheGoCode(ctx context.Context, files []FileInfo) {
+	d.goCodeMux.Lock()
+	defer d.goCodeMux.Unlock()
+
+	if d.goCode != nil {
+		return
+	}
+
+	// ignored offline changes make the current code the one compared with
+	var ps *persistedSnapshot
+	if d.OfflineChanges != OfflineNever {
+		var err error
+		ps, err = d.readSnapshot()
+		if err != nil {
+			d.log(ctx).Debugf("cannot read the code persisted with the snapshot: %s", err)
+		}
+	}
+	var previous Snapshot
+	if ps != nil {
+		previous = NewSnapshot(ps.Files)
+	}
+
+	d.goCode = map[string]string{}
+	for _, f := range files {
+		if filepath.Ext(f.Path) != ".go" {
+			continue
+		}
+		if ps != nil {
+			if code, ok := ps.GoCode[f.Path]; ok {
+				d.goCode[f.Path] = code
+				continue
+			}
+			if p, ok := previous[f.Path]; !ok || !sameFile(p, f) {
+				continue
+			}
+		}
+		d.cacheFile(ctx, f.Path)
+	}
+}
+
+// resetGoCode makes the current code of the watched Go files the one
+// the changes are compared with.
+func (d *Daemon) resetGoCode(ctx context.Context, files []FileInfo) {
+	d.goCodeMux.Lock()
+	defer d.goCodeMux.Unlock()
+
+	d.goCode = map[string]string{}
+	for _, f := range files {
+		if filepath.Ext(f.Path) == ".go" {
+			d.cacheFile(ctx, f.Path)
+		}
+	}
+}
+
+// cacheFile remembers the code of the Go file. The mutex must be held.
+func (d *Daemon) cacheFile(ctx context.Context, path string) {
+	code, err := d.readGoCode(path)
+	if err != nil {
+		d.log(ctx).Debugf("cannot cache %s for semantic comparison: %s", path, err)
+		return
+	}
+	d.goCode[path] = code
+}
+
+// persistedGoCode provides the code of the watched Go files to be
+// persisted with the snapshot.
+func (d *Daemon) persistedGoCode() map[string]string {
+	d.goCodeMux.Lock()
+	defer d.goCodeMux.Unlock()
+
+	if len(d.goCode) == 0 {
+		return nil
+	}
+	code := make(map[string]string, len(d.goCode))
+	for path, c := range d.goCode {
+		code[path] = c
+	}
+	return code
+}
+
+// readGoCode provides the code of the Go file, see goCodeHash.
+func (d *Daemon) readGoCode(path string) (string, error) {
+	content, err := d.fs.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	code, ok := goCodeHash(content)
+	if !ok {
+		return "", errors.New("the file cannot be parsed")
+	}
+	return code, nil
+}
+
+// SameGoCode reports whether two versions of a Go source file have the same
+// syntax tree, ignoring comments and positions. Sources that cannot be parsed
+// are considered different. The comments affecting the build are compared:
+// build constraints, compiler directives, cgo exports and the cgo preamble.
+func SameGoCode(previous, current []byte) bool {
+	a, ok := goCodeHash(previous)
+	if !ok {
+		return false
+	}
+	b, ok := goCodeHash(current)
+	return ok && a == b
+}
+
+// goCodeHash provides a hash of the syntax tree of a Go source file and
+// of its comments affecting the build, see SameGoCode. It reports false
+// if the source cannot be parsed.
+func goCodeHash(src []byte) (string, bool) {
+	f, err := parser.ParseFile(token.NewFileSet(), "", src, parser.ParseComments)
+	if err != nil {
+		return "", false
+	}
+	h := sha256.New()
+	for _, dir := range directives(f) {
+		fmt.Fprintf(h, "%q;", dir)
+	}
+	writeNode(h, reflect.ValueOf(f))
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+// directives provides the comments of the file affecting the build,
+// in the order of appearance.
+func directives(f *ast.File) []string {
+	var found []string
+	for _, group := range f.Comments {
+		for _, c := range group.List {
+			if isDirective(c.Text) {
+				found = append(found, strings.TrimSpace(c.Text))
+			}
+		}
+	}
+
+	// the preamble of the cgo code is the doc comment of import "C"
+	for _, decl := range f.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.IMPORT {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			imp := spec.(*ast.ImportSpec)
+			if imp.Path.Value != `"C"` {
+				continue
+			}
+			doc := imp.Doc
+			if doc == nil && len(gen.Specs) == 1 {
+				doc = gen.Doc
+			}
+			found = append(found, "import \"C\" "+commentText(doc))
+		}
+	}
+	return found
+}
+
+// isDirective reports whether the comment is a build constraint
+// or a directive of the compiler or cgo.
+func isDirective(comment string) bool {
+	return strings.HasPrefix(comment, "//go:") ||
+		strings.HasPrefix(comment, "//line ") ||
+		strings.HasPrefix(comment, "//export ") ||
+		strings.HasPrefix(comment, "// +build")
+}
+
+// commentText provides the raw text of the comment group.
+func commentText(group *ast.CommentGroup) string {
+	if group == nil {
+		return ""
+	}
+	var lines []string
+	for _, c := range group.List {
+		lines = append(lines, c.Text)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// writeNode writes syntax tree nodes recursively, skipping positions,
+// comments (see directives) and resolved objects (which point back to the declarations).
+func writeNode(w io.Writer, v reflect.Value) {
+	switch v.Type() {
+	case posType, commentGroupType, objectType, scopeType:
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			io.WriteString(w, "nil;")
+			return
+		}
+		writeNode(w, v.Elem())
+	case reflect.Struct:
+		fmt.Fprintf(w, "%s{", v.Type())
+		for i := 0; i < v.NumField(); i++ {
+			writeNode(w, v.Field(i))
+		}
+		io.WriteString(w, "}")
+	case reflect.Slice:
+		if v.Type().Elem() == commentGroupType {
+			return
+		}
+		fmt.Fprintf(w, "[%d:", v.Len())
+		for i := 0; i < v.Len(); i++ {
+			writeNode(w, v.Index(i))
+		}
+		io.WriteString(w, "]")
+	case reflect.String:
+		fmt.Fprintf(w, "%q;", v.String())
+	case reflect.Bool:
+		fmt.Fprintf(w, "%t;", v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		fmt.Fprintf(w, "%d;", v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		fmt.Fprintf(w, "%d;", v.Uint())
+	case reflect.Map:
+		// maps are only used by scopes and packages, which are skipped
+	default:
+		fmt.Fprintf(w, "%s;", v.Kind())
+	}
+}
@@ -0,0 +1,196 @@
+// +build unit_tests
+
+package daemon_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tamarakaufler/watcher-daemon/internal/daemon"
+)
+
+const goSource = `package a
+
+// A does something.
+func A(x int) int {
+	return x + 1
+}
+`
+
+func TestSameGoCode(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		current string
+		want    bool
+	}{
+		{
+			name:    "identical content",
+			current: goSource,
+			want:    true,
+		},
+		{
+			name: "changed comment",
+			current: `package a
+
+// A adds one.
+// Second line.
+func A(x int) int {
+	return x + 1 // incremented
+}
+`,
+			want: true,
+		},
+		{
+			name: "changed formatting",
+			current: `package a
+// A does something.
+func A(x   int) int { return x+1 }
+`,
+			want: true,
+		},
+		{
+			name: "changed code",
+			current: `package a
+
+// A does something.
+func A(x int) int {
+	return x + 2
+}
+`,
+			want: false,
+		},
+		{
+			name: "renamed parameter",
+			current: `package a
+
+// A does something.
+func A(y int) int {
+	return y + 1
+}
+`,
+			want: false,
+		},
+		{
+			name: "added declaration",
+			current: goSource + `
+var B = 1
+`,
+			want: false,
+		},
+		{
+			name:    "invalid code",
+			current: "package a\n\nfunc A( {\n",
+			want:    false,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			got := daemon.SameGoCode([]byte(goSource), []byte(tt.current))
+			if got != tt.want {
+				t.Errorf("SameGoCode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSameGoCode_Directives(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		previous string
+		current  string
+		want     bool
+	}{
+		{
+			name:     "added go:build constraint",
+			previous: goSource,
+			current:  "//go:build ignore\n\n" + goSource,
+			want:     false,
+		},
+		{
+			name:     "changed +build constraint",
+			previous: "// +build linux\n\n" + goSource,
+			current:  "// +build darwin\n\n" + goSource,
+			want:     false,
+		},
+		{
+			name:     "changed go:embed path",
+			previous: "package a\n\nimport _ \"embed\"\n\n//go:embed a.txt\nvar A string\n",
+			current:  "package a\n\nimport _ \"embed\"\n\n//go:embed b.txt\nvar A string\n",
+			want:     false,
+		},
+		{
+			name:     "changed go:generate command",
+			previous: "package a\n\n//go:generate stringer -type=A\ntype A int\n",
+			current:  "package a\n\n//go:generate stringer -type=A -trimprefix=A\ntype A int\n",
+			want:     false,
+		},
+		{
+			name:     "added go:linkname",
+			previous: "package a\n\nimport _ \"unsafe\"\n\nfunc now() int64\n",
+			current:  "package a\n\nimport _ \"unsafe\"\n\n//go:linkname now runtime.nanotime\nfunc now() int64\n",
+			want:     false,
+		},
+		{
+			name:     "added cgo export",
+			previous: "package a\n\nimport \"C\"\n\nfunc A() {}\n",
+			current:  "package a\n\nimport \"C\"\n\n//export A\nfunc A() {}\n",
+			want:     false,
+		},
+		{
+			name:     "changed cgo preamble",
+			previous: "package a\n\n// #include <stdio.h>\nimport \"C\"\n",
+			current:  "package a\n\n// #cgo LDFLAGS: -lm\n// #include <math.h>\nimport \"C\"\n",
+			want:     false,
+		},
+		{
+			name:     "changed comment next to directives",
+			previous: "//go:build linux\n\n// Package a does something.\n" + goSource,
+			current:  "//go:build linux\n\n// Package a does something else.\n" + goSource,
+			want:     true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			got := daemon.SameGoCode([]byte(tt.previous), []byte(tt.current))
+			if got != tt.want {
+				t.Errorf("SameGoCode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDaemon_FilterSemanticChanges(t *testing.T) {
+	dir := t.TempDir()
+	goFile := filepath.Join(dir, "a.go")
+	txtFile := filepath.Join(dir, "a.txt")
+
+	os.Setenv("WATCHER_DAEMON_BASE_PATH", dir)
+	d, err := daemon.New()
+	require.Nil(t, err, "daemon creation failure")
+
+	changes := daemon.ChangeSet{
+		{Path: goFile, Name: "a.go", Op: daemon.OpModified},
+		{Path: txtFile, Name: "a.txt", Op: daemon.OpModified},
+	}
+
+	require.Nil(t, ioutil.WriteFile(goFile, []byte(goSource), 0600))
+	require.Equal(t, changes, d.FilterSemanticChanges(context.Background(), changes), "first seen file must be kept")
+
+	commentOnly := "// Package a is an example.\n" + goSource
+	require.Nil(t, ioutil.WriteFile(goFile, []byte(commentOnly), 0600))
+	require.Equal(t, changes[1:], d.FilterSemanticChanges(context.Background(), changes), "comment change must be dropped")
+
+	changed := goSource + "\nvar B = 1\n"
+	require.Nil(t, ioutil.WriteFile(goFile, []byte(changed), 0600))
+	require.Equal(t, changes, d.FilterSemanticChanges(context.Background(), changes), "code change must be kept")
+}
@@ -0,0 +1,163 @@
+package daemon
+
+import (
+	"context"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// serviceStopTimeout is how long the service has to exit after being
+// asked to stop, before it is killed.
+const serviceStopTimeout = 5 * time.Second
+
+// service is a running instance of the supervised service.
+type service struct {
+	cmd     *exec.Cmd
+	stopped chan struct{} // closed when the service is asked to stop
+	done    chan struct{} // closed when the service exited
+	// closed when the service logged a line matching the readiness regex
+	logReady chan struct{}
+}
+
+// Restart runs the command for the detected changes and, in restart mode,
+// restarts the service. The service is stopped before the command runs
+// and started again only if the command succeeds. The run succeeds once
+// the service is ready. Requests coming to the proxy are held meanwhile.
+func (d *Daemon) Restart(ctx context.Context, changes ChangeSet) Run {
+	if d.Service == "" {
+		return d.RunCommand(ctx, changes)
+	}
+
+	d.proxy.hold()
+	d.StopService()
+
+	run := d.runCommand(ctx, changes)
+	ctx = d.withRunID(ctx, run.ID)
+	if !run.Succeeded() {
+		d.proxy.fail("The command failed", run.Error, run.Output)
+		return d.finishRun(ctx, run)
+	}
+
+	// the daemon stopping meanwhile would not stop a service started now
+	if err := ctx.Err(); err != nil {
+		run.Service = ServiceNotStarted
+		run.Error = errors.Wrap(err, "the service is not started").Error()
+		d.proxy.fail("The service is not started", run.Error, "")
+		return d.finishRun(ctx, run)
+	}
+
+	s, err := d.startService(ctx)
+	if err != nil {
+		run.Service = ServiceNotStarted
+		run.Error = err.Error()
+		d.proxy.fail("The service failed to start", run.Error, "")
+		return d.finishRun(ctx, run)
+	}
+
+	if err := d.waitReady(ctx, s); err != nil {
+		run.Service = ServiceNotReady
+		run.Error = errors.Wrap(err, "the service failed to become ready").Error()
+		d.log(ctx).Errorf("%s", run.Error)
+		d.proxy.fail("The service failed to become ready", run.Error, "")
+		return d.finishRun(ctx, run)
+	}
+	run.Service = ServiceReady
+	d.log(ctx).Infof("service ready")
+	d.proxy.open()
+
+	return d.finishRun(ctx, run)
+}
+
+func (d *Daemon) startService(ctx context.Context) (*service, error) {
+	log := d.log(ctx)
+
+	args := strings.Fields(d.Service)
+	if len(args) == 0 {
+		return nil, errors.New("the service is empty")
+	}
+
+	s := &service{
+		stopped:  make(chan struct{}),
+		done:     make(chan struct{}),
+		logReady: make(chan struct{}),
+	}
+
+	var once sync.Once
+	lines := &lineWriter{publish: func(line string) {
+		if d.readyLog != nil && d.readyLog.MatchString(line) {
+			once.Do(func() { close(s.logReady) })
+		}
+	}}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = d.BasePath
+	cmd.Stdout = io.MultiWriter(d.stdout, lines)
+	cmd.Stderr = d.stderr
+	// the service may run the actual server as a child, eg go run or npm
+	newProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, errors.Wrapf(err, "error starting the service %s", d.Service)
+	}
+	log.Infof("service started (pid %d)", cmd.Process.Pid)
+
+	s.cmd = cmd
+	go func() {
+		defer close(s.done)
+		err := cmd.Wait()
+		select {
+		case <-s.stopped:
+			log.Infof("service (pid %d) stopped", cmd.Process.Pid)
+		default:
+			if err == nil {
+				err = errors.New("exit status 0")
+			}
+			log.Warnf("service (pid %d) exited unexpectedly: %s", cmd.Process.Pid, err)
+		}
+	}()
+
+	d.serviceMux.Lock()
+	d.service = s
+	d.serviceMux.Unlock()
+
+	return s, nil
+}
+
+// StopService stops the running service and its children, killing them
+// if the service does not exit in time.
+func (d *Daemon) StopService() {
+	d.serviceMux.Lock()
+	s := d.service
+	d.service = nil
+	d.serviceMux.Unlock()
+
+	if s == nil {
+		return
+	}
+
+	select {
+	case <-s.done:
+		// the children may have outlived the service
+		_ = terminateProcessGroup(s.cmd)
+		return
+	default:
+	}
+
+	close(s.stopped)
+	if err := terminateProcessGroup(s.cmd); err != nil {
+		d.logger.Warnf("cannot stop the service: %s", err)
+	}
+	select {
+	case <-s.done:
+	case <-d.clock.After(serviceStopTimeout):
+		d.logger.Warnf("service (pid %d) did not stop in %s, killing it", s.cmd.Process.Pid, serviceStopTimeout)
+		if err := killProcessGroup(s.cmd); err != nil {
+			d.logger.Warnf("cannot kill the service: %s", err)
+		}
+		<-s.done
+	}
+}
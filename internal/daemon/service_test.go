@@ -0,0 +1,97 @@
+// +build unit_tests
+
+package daemon_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tamarakaufler/watcher-daemon/internal/daemon"
+)
+
+// wrapperScript runs the server as a child, as go run or npm do.
+const wrapperScript = `sleep 60 >/dev/null 2>&1 &
+echo $! > server.pid
+echo started
+wait
+`
+
+func TestDaemon_StopService_Children(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "wrapper.sh"), []byte(wrapperScript), 0644))
+
+	d, err := daemon.NewWithEnvironment(map[string]string{
+		"WATCHER_DAEMON_COMMAND":       "true",
+		"WATCHER_DAEMON_SERVICE":       "sh wrapper.sh",
+		"WATCHER_DAEMON_READY_LOG":     "started",
+		"WATCHER_DAEMON_READY_TIMEOUT": "5",
+	},
+		daemon.WithStateDir(""),
+		daemon.WithBasePath(dir),
+		daemon.WithStdout(ioutil.Discard),
+	)
+	require.Nil(t, err, "daemon creation failure")
+
+	run := d.Restart(context.Background(), nil)
+	require.True(t, run.Succeeded(), run.Error)
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "server.pid"))
+	require.Nil(t, err)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	require.Nil(t, err)
+	require.True(t, isRunning(pid), "the server is not running")
+
+	d.StopService()
+	require.Eventually(t, func() bool {
+		return !isRunning(pid)
+	}, 5*time.Second, 10*time.Millisecond, "the server outlived the service")
+}
+
+// isRunning reports whether the process exists and is not a zombie waiting
+// to be reaped.
+func isRunning(pid int) bool {
+	if stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid)); err == nil {
+		if fields := strings.Fields(string(stat)); len(fields) > 2 && fields[2] == "Z" {
+			return false
+		}
+	}
+	p, err := os.FindProcess(pid)
+	return err == nil && p.Signal(syscall.Signal(0)) == nil
+}
+
+func TestDaemon_Restart_Cancelled(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "started")
+	d, err := daemon.NewWithEnvironment(map[string]string{
+		"WATCHER_DAEMON_COMMAND": "true",
+		"WATCHER_DAEMON_SERVICE": "touch " + marker,
+	},
+		daemon.WithStateDir(""),
+		daemon.WithBasePath(dir),
+		daemon.WithStdout(ioutil.Discard),
+	)
+	require.Nil(t, err, "daemon creation failure")
+
+	// the daemon stopped while the command ran
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	run := d.Restart(ctx, nil)
+	require.False(t, run.Succeeded())
+	require.Equal(t, daemon.ServiceNotStarted, run.Service)
+
+	_, err = os.Stat(marker)
+	require.True(t, os.IsNotExist(err), "the service must not be started")
+}
@@ -0,0 +1,222 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// snapshotFile is the name of the snapshot file within the state directory.
+const snapshotFile = "snapshot.json"
+
+// Handling of changes made while the daemon was not running.
+const (
+	// OfflineAlways runs the command once on startup, even without changes.
+	OfflineAlways = "always"
+	// OfflineChanged runs the command once on startup if files changed
+	// since the persisted snapshot.
+	OfflineChanged = "changed"
+	// OfflineNever ignores changes made while the daemon was not running.
+	OfflineNever = "never"
+)
+
+// Snapshot maps paths of the watched files to their details.
+type Snapshot map[string]FileInfo
+
+// persistedSnapshot is the content of the snapshot file.
+type persistedSnapshot struct {
+	BasePath string `json:"base_path"`
+	// Watched describes the watched files, see watchedKey
+	Watched string     `json:"watched"`
+	Files   []FileInfo `json:"files"`
+	// GoCode is the code of the Go files compared by the semantic filter
+	GoCode map[string]string `json:"go_code,omitempty"`
+}
+
+// NewSnapshot creates a snapshot of the collected files.
+func NewSnapshot(files []FileInfo) Snapshot {
+	s := make(Snapshot, len(files))
+	for _, f := range files {
+		s[f.Path] = f
+	}
+	return s
+}
+
+// Diff provides changes between the previous snapshot and this one,
+// sorted by path.
+func (s Snapshot) Diff(previous Snapshot) ChangeSet {
+	var changes ChangeSet
+
+	for path, f := range s {
+		p, ok := previous[path]
+		switch {
+		case !ok:
+			changes = append(changes, Change{Path: path, Name: f.Name, Op: OpCreated})
+		case !sameFile(p, f):
+			changes = append(changes, Change{Path: path, Name: f.Name, Op: OpModified})
+		}
+	}
+	for path, p := range previous {
+		if _, ok := s[path]; !ok {
+			changes = append(changes, Change{Path: path, Name: p.Name, Op: OpRemoved})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes
+}
+
+// sameFile reports whether the file did not change between the snapshots.
+func sameFile(previous, current FileInfo) bool {
+	return previous.ModTime.Equal(current.ModTime) && previous.Size == current.Size &&
+		previous.Target == current.Target
+}
+
+// DetectChanges compares the collected files with the previous snapshot and
+// reports whether the command should run. On the first scan, the previous
+// snapshot is the one persisted before the daemon stopped, if any, and it is
+// used according to the OfflineChanges configuration.
+func (d *Daemon) DetectChanges(ctx context.Context, files []FileInfo) (ChangeSet, bool) {
+	d.snapshotMux.Lock()
+	defer d.snapshotMux.Unlock()
+
+	current := NewSnapshot(files)
+	previous := d.snapshot
+	first := previous == nil
+	if first {
+		var err error
+		previous, err = d.loadSnapshot()
+		if err != nil {
+			d.log(ctx).Warnf("cannot load persisted snapshot: %s", err)
+		}
+	}
+	d.snapshot = current
+
+	var changes ChangeSet
+	if previous != nil {
+		changes = current.Diff(previous)
+	}
+	if first || len(changes) != 0 {
+		if err := d.saveSnapshot(current); err != nil {
+			d.log(ctx).Warnf("cannot persist snapshot: %s", err)
+		}
+	}
+
+	if !first {
+		return changes, len(changes) != 0
+	}
+
+	switch d.OfflineChanges {
+	case OfflineAlways:
+		d.log(ctx).Infof("running the command on startup (%d offline changes)", len(changes))
+		return changes, true
+	case OfflineChanged:
+		if len(changes) != 0 {
+			d.log(ctx).Infof("running the command for %d offline changes", len(changes))
+		}
+		return changes, len(changes) != 0
+	}
+	return nil, false
+}
+
+// SaveSnapshot persists the latest snapshot in the state directory.
+func (d *Daemon) SaveSnapshot() error {
+	d.snapshotMux.Lock()
+	defer d.snapshotMux.Unlock()
+
+	if d.snapshot == nil {
+		return nil
+	}
+	return d.saveSnapshot(d.snapshot)
+}
+
+func (d *Daemon) saveSnapshot(s Snapshot) error {
+	if d.StateDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(d.StateDir, 0755); err != nil {
+		return errors.Wrapf(err, "cannot create state directory %s", d.StateDir)
+	}
+
+	ps := persistedSnapshot{
+		BasePath: d.BasePath,
+		Watched:  d.watchedKey(),
+		Files:    make([]FileInfo, 0, len(s)),
+		GoCode:   d.persistedGoCode(),
+	}
+	for _, f := range s {
+		ps.Files = append(ps.Files, f)
+	}
+	sort.Slice(ps.Files, func(i, j int) bool {
+		return ps.Files[i].Path < ps.Files[j].Path
+	})
+
+	data, err := json.Marshal(ps)
+	if err != nil {
+		return errors.Wrap(err, "cannot encode snapshot")
+	}
+
+	// written to a temporary file first, so that a crash does not leave
+	// a partially written snapshot behind
+	path := filepath.Join(d.StateDir, snapshotFile)
+	if err := ioutil.WriteFile(path+".tmp", data, 0644); err != nil {
+		return errors.Wrap(err, "cannot write snapshot")
+	}
+	return errors.Wrap(os.Rename(path+".tmp", path), "cannot write snapshot")
+}
+
+// loadSnapshot provides the persisted snapshot, or nil if there is none
+// of the watched files.
+func (d *Daemon) loadSnapshot() (Snapshot, error) {
+	ps, err := d.readSnapshot()
+	if ps == nil {
+		return nil, err
+	}
+	return NewSnapshot(ps.Files), nil
+}
+
+// readSnapshot reads the persisted snapshot, nil if there is none
+// of the watched files.
+func (d *Daemon) readSnapshot() (*persistedSnapshot, error) {
+	if d.StateDir == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(filepath.Join(d.StateDir, snapshotFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read snapshot")
+	}
+
+	var ps persistedSnapshot
+	if err := json.Unmarshal(data, &ps); err != nil {
+		return nil, errors.Wrap(err, "cannot decode snapshot")
+	}
+	if ps.BasePath != d.BasePath || ps.Watched != d.watchedKey() {
+		d.logger.Debugf("the persisted snapshot is of other watched files")
+		return nil, nil
+	}
+	return &ps, nil
+}
+
+// watchedKey describes the watched files, ie the roots with their patterns
+// and the criteria of the daemon, so that a snapshot persisted with another
+// configuration is not mistaken for the previous state of the files.
+func (d *Daemon) watchedKey() string {
+	data, _ := json.Marshal(struct {
+		Roots          []Root
+		Extension      string
+		Include        []string
+		Excluded       []string
+		FollowSymlinks bool
+	}{d.watchedRoots(), d.Extention, d.include, d.excluded, d.FollowSymlinks})
+	return string(data)
+}
@@ -0,0 +1,207 @@
+// +build unit_tests
+
+package daemon_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tamarakaufler/watcher-daemon/internal/daemon"
+)
+
+func TestSnapshot_Diff(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	previous := daemon.NewSnapshot([]daemon.FileInfo{
+		{Path: "a/unchanged.go", Name: "unchanged.go", ModTime: now, Size: 10},
+		{Path: "a/touched.go", Name: "touched.go", ModTime: now, Size: 10},
+		{Path: "a/resized.go", Name: "resized.go", ModTime: now, Size: 10},
+		{Path: "a/removed.go", Name: "removed.go", ModTime: now, Size: 10},
+	})
+	current := daemon.NewSnapshot([]daemon.FileInfo{
+		{Path: "a/unchanged.go", Name: "unchanged.go", ModTime: now, Size: 10},
+		{Path: "a/touched.go", Name: "touched.go", ModTime: now.Add(time.Second), Size: 10},
+		{Path: "a/resized.go", Name: "resized.go", ModTime: now, Size: 20},
+		{Path: "a/created.go", Name: "created.go", ModTime: now, Size: 10},
+	})
+
+	want := daemon.ChangeSet{
+		{Path: "a/created.go", Name: "created.go", Op: daemon.OpCreated},
+		{Path: "a/removed.go", Name: "removed.go", Op: daemon.OpRemoved},
+		{Path: "a/resized.go", Name: "resized.go", Op: daemon.OpModified},
+		{Path: "a/touched.go", Name: "touched.go", Op: daemon.OpModified},
+	}
+	require.Equal(t, want, current.Diff(previous))
+	require.Empty(t, current.Diff(current))
+}
+
+func TestDaemon_DetectChanges(t *testing.T) {
+	tests := []struct {
+		name           string
+		offlineChanges string
+		persisted      bool
+		offlineChange  bool
+		wantChanges    daemon.ChangeSet
+		wantRun        bool
+	}{
+		{
+			name:           "offline change with the changed mode",
+			offlineChanges: daemon.OfflineChanged,
+			persisted:      true,
+			offlineChange:  true,
+			wantChanges:    daemon.ChangeSet{{Path: "b.go", Name: "b.go", Op: daemon.OpCreated}},
+			wantRun:        true,
+		},
+		{
+			name:           "no offline change with the changed mode",
+			offlineChanges: daemon.OfflineChanged,
+			persisted:      true,
+			wantRun:        false,
+		},
+		{
+			name:           "no persisted snapshot with the changed mode",
+			offlineChanges: daemon.OfflineChanged,
+			wantRun:        false,
+		},
+		{
+			name:           "offline change with the never mode",
+			offlineChanges: daemon.OfflineNever,
+			persisted:      true,
+			offlineChange:  true,
+			wantRun:        false,
+		},
+		{
+			name:           "no offline change with the always mode",
+			offlineChanges: daemon.OfflineAlways,
+			persisted:      true,
+			wantRun:        true,
+		},
+		{
+			name:           "no persisted snapshot with the always mode",
+			offlineChanges: daemon.OfflineAlways,
+			wantRun:        true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			base := t.TempDir()
+			require.Nil(t, ioutil.WriteFile(filepath.Join(base, "a.go"), []byte("package a\n"), 0600))
+
+			os.Setenv("WATCHER_DAEMON_BASE_PATH", base)
+			os.Setenv("WATCHER_DAEMON_EXCLUDED", "")
+			os.Setenv("WATCHER_DAEMON_STATE_DIR", t.TempDir())
+			os.Setenv("WATCHER_DAEMON_OFFLINE_CHANGES", tt.offlineChanges)
+			defer os.Unsetenv("WATCHER_DAEMON_STATE_DIR")
+			defer os.Unsetenv("WATCHER_DAEMON_OFFLINE_CHANGES")
+
+			ctx := context.Background()
+			if tt.persisted {
+				// the previous run of the daemon
+				d, err := daemon.New()
+				require.Nil(t, err, "daemon creation failure")
+				files, err := d.CollectFiles(ctx)
+				require.Nil(t, err)
+				d.DetectChanges(context.Background(), files)
+				require.Nil(t, d.SaveSnapshot())
+			}
+			if tt.offlineChange {
+				require.Nil(t, ioutil.WriteFile(filepath.Join(base, "b.go"), []byte("package a\n"), 0600))
+			}
+
+			d, err := daemon.New()
+			require.Nil(t, err, "daemon creation failure")
+			files, err := d.CollectFiles(ctx)
+			require.Nil(t, err)
+
+			var wantChanges daemon.ChangeSet
+			for _, c := range tt.wantChanges {
+				c.Path = filepath.Join(base, c.Path)
+				wantChanges = append(wantChanges, c)
+			}
+			changes, run := d.DetectChanges(context.Background(), files)
+			require.Equal(t, wantChanges, changes)
+			require.Equal(t, tt.wantRun, run)
+
+			// changes are detected against the previous scan afterwards
+			require.Nil(t, os.Remove(filepath.Join(base, "a.go")))
+			files, err = d.CollectFiles(ctx)
+			require.Nil(t, err)
+			changes, run = d.DetectChanges(context.Background(), files)
+			removed := daemon.ChangeSet{{Path: filepath.Join(base, "a.go"), Name: "a.go", Op: daemon.OpRemoved}}
+			require.Equal(t, removed, changes)
+			require.True(t, run)
+		})
+	}
+}
+
+func TestDaemon_DetectChanges_OtherRoots(t *testing.T) {
+	t.Parallel()
+
+	base, shared, state := t.TempDir(), t.TempDir(), t.TempDir()
+	require.Nil(t, ioutil.WriteFile(filepath.Join(base, "a.go"), []byte("package a\n"), 0600))
+	require.Nil(t, ioutil.WriteFile(filepath.Join(shared, "b.go"), []byte("package b\n"), 0600))
+
+	ctx := context.Background()
+	detect := func(environment map[string]string) (daemon.ChangeSet, bool) {
+		d, err := daemon.NewWithEnvironment(environment,
+			daemon.WithBasePath(base),
+			daemon.WithStateDir(state),
+		)
+		require.Nil(t, err, "daemon creation failure")
+		files, err := d.CollectFiles(ctx)
+		require.Nil(t, err)
+		changes, run := d.DetectChanges(ctx, files)
+		require.Nil(t, d.SaveSnapshot())
+		return changes, run
+	}
+
+	_, run := detect(map[string]string{})
+	require.False(t, run)
+
+	// the snapshot of the base path alone is not the previous state
+	// of the base path with another root
+	changes, run := detect(map[string]string{"WATCHER_DAEMON_ROOTS": shared})
+	require.Empty(t, changes)
+	require.False(t, run)
+
+	changes, run = detect(map[string]string{"WATCHER_DAEMON_ROOTS": shared + "?exclude=b.go"})
+	require.Empty(t, changes)
+	require.False(t, run)
+}
+
+func TestNewWithEnvironment_DefaultStateDir(t *testing.T) {
+	t.Parallel()
+
+	base := t.TempDir()
+	require.Nil(t, ioutil.WriteFile(filepath.Join(base, "a.go"), []byte("package a\n"), 0600))
+
+	d, err := daemon.NewWithEnvironment(map[string]string{
+		"WATCHER_DAEMON_BASE_PATH": base,
+	}, daemon.WithInclude("*.go", "*.json"))
+	require.Nil(t, err, "daemon creation failure")
+	require.Equal(t, filepath.Join(base, ".watcher-daemon"), d.StateDir)
+
+	// the state is not watched
+	ctx := context.Background()
+	_, _, err = d.Scan(ctx)
+	require.Nil(t, err)
+	require.Nil(t, d.SaveSnapshot())
+	files, err := d.CollectFiles(ctx)
+	require.Nil(t, err)
+	require.Len(t, files, 1)
+	require.Equal(t, filepath.Join(base, "a.go"), files[0].Path)
+
+	d, err = daemon.NewWithEnvironment(map[string]string{
+		"WATCHER_DAEMON_BASE_PATH": base,
+		"WATCHER_DAEMON_STATE_DIR": "state",
+	})
+	require.Nil(t, err, "daemon creation failure")
+	require.Equal(t, "state", d.StateDir)
+}
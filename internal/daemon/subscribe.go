@@ -0,0 +1,99 @@
+package daemon
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Policy decides what happens to a change batch when the buffer
+// of a subscriber is full.
+type Policy int
+
+const (
+	// PolicyDrop drops the batch for the subscriber, so that a slow subscriber
+	// never delays the watch.
+	PolicyDrop Policy = iota
+	// PolicyBlock waits until the subscriber receives the batch, so that
+	// no batch is lost. The watch is paused meanwhile.
+	PolicyBlock
+)
+
+// defaultSubscriptionBuffer is the default number of batches buffered
+// for each subscriber.
+const defaultSubscriptionBuffer = 16
+
+// ChangeBatch is a set of changes passed to the command, or an empty set
+// when the run was triggered.
+type ChangeBatch struct {
+	Time    time.Time
+	Changes ChangeSet
+}
+
+// subscription is a subscriber of the change batches.
+type subscription struct {
+	ch   chan ChangeBatch
+	done <-chan struct{}
+}
+
+// subscriptions passes the change batches to the subscribers.
+type subscriptions struct {
+	mux  sync.Mutex
+	subs map[*subscription]struct{}
+}
+
+func newSubscriptions() *subscriptions {
+	return &subscriptions{subs: map[*subscription]struct{}{}}
+}
+
+// Subscribe provides a channel receiving the change batches passed
+// to the command, until the context is done, when the channel is closed.
+// The batches are buffered and, when the buffer is full, dropped or waited
+// for depending on the configured policy.
+func (d *Daemon) Subscribe(ctx context.Context) <-chan ChangeBatch {
+	sub := &subscription{
+		ch:   make(chan ChangeBatch, d.subscriptionBuffer),
+		done: ctx.Done(),
+	}
+
+	d.subscriptions.mux.Lock()
+	d.subscriptions.subs[sub] = struct{}{}
+	d.subscriptions.mux.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		// a blocked delivery gives up once the context is done
+		d.subscriptions.mux.Lock()
+		delete(d.subscriptions.subs, sub)
+		close(sub.ch)
+		d.subscriptions.mux.Unlock()
+	}()
+
+	return sub.ch
+}
+
+// deliver passes the changes to the subscribers.
+func (d *Daemon) deliver(ctx context.Context, changes ChangeSet) {
+	batch := ChangeBatch{Time: d.clock.Now(), Changes: changes}
+
+	d.subscriptions.mux.Lock()
+	defer d.subscriptions.mux.Unlock()
+
+	for sub := range d.subscriptions.subs {
+		if d.subscriptionPolicy == PolicyBlock {
+			select {
+			case sub.ch <- batch:
+			case <-sub.done:
+			case <-ctx.Done():
+			}
+			continue
+		}
+
+		select {
+		case sub.ch <- batch:
+		default:
+			d.logger.Warnf("change batch dropped for a slow subscriber")
+		}
+	}
+}
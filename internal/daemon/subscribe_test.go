@@ -0,0 +1,104 @@
+// +build unit_tests
+
+package daemon_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tamarakaufler/watcher-daemon/internal/daemon"
+)
+
+// changingBackend provides a file modified at each check.
+type changingBackend struct {
+	mux    sync.Mutex
+	checks int
+}
+
+func (b *changingBackend) Files(ctx context.Context) ([]daemon.FileInfo, error) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	b.checks++
+	return []daemon.FileInfo{{
+		Path:    "a.go",
+		Name:    "a.go",
+		ModTime: time.Date(2021, 3, 1, 10, 0, b.checks, 0, time.UTC),
+	}}, nil
+}
+
+func (b *changingBackend) Checks() int {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return b.checks
+}
+
+func TestDaemon_Subscribe(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    daemon.Policy
+		maxChecks int // while the subscriber is not receiving
+	}{
+		{
+			name:   "drop",
+			policy: daemon.PolicyDrop,
+		},
+		{
+			name:   "block",
+			policy: daemon.PolicyBlock,
+			// the baseline, the buffered batch and the blocked one
+			maxChecks: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &changingBackend{}
+			d, err := daemon.NewWithEnvironment(map[string]string{},
+				daemon.WithStateDir(""),
+				daemon.WithBackend(backend),
+				daemon.WithInterval(5*time.Millisecond),
+				daemon.WithHandler(func(context.Context, daemon.ChangeSet) error { return nil }),
+				daemon.WithSubscriptionBuffer(1),
+				daemon.WithSubscriptionPolicy(tt.policy),
+			)
+			require.Nil(t, err, "daemon creation failure")
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			subCtx, unsubscribe := context.WithCancel(ctx)
+			batches := d.Subscribe(subCtx)
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				d.Watch(ctx, nil)
+			}()
+
+			time.Sleep(100 * time.Millisecond)
+			checks := backend.Checks()
+			if tt.maxChecks != 0 {
+				require.LessOrEqual(t, checks, tt.maxChecks, "a blocked subscriber must pause the watch")
+			} else {
+				require.Greater(t, checks, 5, "a slow subscriber must not pause the watch")
+			}
+
+			batch := <-batches
+			require.Equal(t, daemon.ChangeSet{{Path: "a.go", Name: "a.go", Op: daemon.OpModified}}, batch.Changes)
+			require.False(t, batch.Time.IsZero())
+
+			unsubscribe()
+			for range batches {
+			}
+
+			// the watch goes on without the subscriber
+			time.Sleep(50 * time.Millisecond)
+			require.Greater(t, backend.Checks(), checks)
+
+			cancel()
+			<-done
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package daemon
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// collectFollowing collects the watched files of the root directory,
+// following symlinks.
+func (d *Daemon) collectFollowing(ctx context.Context, r Root) ([]FileInfo, error) {
+	real, err := d.fs.EvalSymlinks(r.Path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error collecting files from %s", r.Path)
+	}
+
+	var files []FileInfo
+	if err := d.walkFollowing(ctx, r, r.Path, real, map[string]bool{}, &files); err != nil {
+		return nil, errors.Wrapf(err, "error collecting files from %s", r.Path)
+	}
+	return files, nil
+}
+
+// walkFollowing collects the watched files of the directory at the real
+// path, named dir, following symlinks. The visited directories are tracked
+// by their real paths, so that each is walked once and cycles end.
+func (d *Daemon) walkFollowing(ctx context.Context, r Root, dir, real string,
+	visited map[string]bool, files *[]FileInfo) error {
+	return d.fs.Walk(real, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			d.metrics.walkErrors.Inc()
+			return err
+		}
+		rel, err := filepath.Rel(real, p)
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(dir, rel)
+
+		switch {
+		case info.IsDir():
+			if visited[absPath(p)] || d.isStateDir(path) {
+				return filepath.SkipDir
+			}
+			visited[absPath(p)] = true
+			return nil
+		case strings.HasPrefix(path, ".git"):
+			return nil
+		case info.Mode()&os.ModeSymlink != 0:
+			return d.followSymlink(ctx, r, path, p, visited, files)
+		default:
+			return d.collectFollowed(ctx, r, path, p, info, files)
+		}
+	})
+}
+
+// followSymlink collects the watched files behind the symlink. A broken
+// symlink has no files.
+func (d *Daemon) followSymlink(ctx context.Context, r Root, path, link string,
+	visited map[string]bool, files *[]FileInfo) error {
+	target, err := d.fs.EvalSymlinks(link)
+	if err != nil {
+		d.log(ctx).Debugf("cannot follow the symlink %s: %s", path, err)
+		return nil
+	}
+	info, err := d.fs.Stat(target)
+	if err != nil {
+		d.log(ctx).Debugf("cannot follow the symlink %s: %s", path, err)
+		return nil
+	}
+
+	if !info.IsDir() {
+		return d.collectFollowed(ctx, r, path, target, info, files)
+	}
+	if visited[absPath(target)] {
+		d.log(ctx).Debugf("symlink %s not followed, %s is already watched", path, target)
+		return nil
+	}
+	return d.walkFollowing(ctx, r, path, target, visited, files)
+}
+
+// collectFollowed collects the file at the real path, named path, if it is
+// watched.
+func (d *Daemon) collectFollowed(ctx context.Context, r Root, path, real string,
+	info os.FileInfo, files *[]FileInfo) error {
+	name := filepath.Base(path)
+	watched, err := d.isWatchedFile(ctx, r, path, name)
+	if err != nil || !watched {
+		return err
+	}
+
+	f := FileInfo{
+		Path:    path,
+		Name:    name,
+		ModTime: info.ModTime(),
+		Size:    info.Size(),
+	}
+	if real != path {
+		f.Target = real
+	}
+	*files = append(*files, f)
+	return nil
+}
+
+// absPath provides the absolute path, or the path if it cannot be made
+// absolute.
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
@@ -0,0 +1,117 @@
+// +build unit_tests
+
+package daemon_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tamarakaufler/watcher-daemon/internal/daemon"
+)
+
+func collectedFiles(t *testing.T, d *daemon.Daemon) map[string]string {
+	t.Helper()
+
+	files, err := d.CollectFiles(context.Background())
+	require.Nil(t, err)
+	targets := map[string]string{}
+	for _, f := range files {
+		targets[f.Path] = f.Target
+	}
+	return targets
+}
+
+func TestDaemon_FollowSymlinks(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	modTime := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	fs := daemon.NewMemFileSystem()
+	fs.WriteFile("src/main.go", []byte("package main\n"), modTime)
+	// a package symlinked from outside the tree
+	fs.WriteFile("libs/lib/lib.go", []byte("package lib\n"), modTime)
+	fs.Symlink("../libs/lib", "src/vendor-lib")
+	// a cycle
+	fs.Symlink(".", "src/loop")
+	fs.Symlink("missing.go", "src/broken.go")
+	// a Kubernetes-style mounted config
+	fs.WriteFile("src/config/..2021_03_01/app.go", []byte("package config\n"), modTime)
+	fs.Symlink("..2021_03_01", "src/config/..data")
+	fs.Symlink("..data/app.go", "src/config/app.go")
+
+	d, err := daemon.NewWithEnvironment(map[string]string{},
+		daemon.WithStateDir(""),
+		daemon.WithFileSystem(fs),
+		daemon.WithBasePath("src"),
+	)
+	require.Nil(t, err, "daemon creation failure")
+	require.NotContains(t, collectedFiles(t, d), "src/vendor-lib/lib.go")
+
+	d, err = daemon.NewWithEnvironment(map[string]string{
+		"WATCHER_DAEMON_FOLLOW_SYMLINKS": "true",
+	},
+		daemon.WithStateDir(""),
+		daemon.WithFileSystem(fs),
+		daemon.WithBasePath("src"),
+	)
+	require.Nil(t, err, "daemon creation failure")
+	require.Equal(t, map[string]string{
+		"src/config/..2021_03_01/app.go": "",
+		"src/config/app.go":              "src/config/..2021_03_01/app.go",
+		"src/main.go":                    "",
+		"src/vendor-lib/lib.go":          "libs/lib/lib.go",
+	}, collectedFiles(t, d))
+
+	_, _, err = d.Scan(ctx)
+	require.Nil(t, err)
+
+	// the targets are swapped for identical files
+	fs.WriteFile("src/config/..2021_03_02/app.go", []byte("package config\n"), modTime)
+	fs.Symlink("..2021_03_02", "src/config/..data")
+	require.Nil(t, fs.Remove("src/config/..2021_03_01/app.go"))
+	fs.WriteFile("libs/lib2/lib.go", []byte("package lib\n"), modTime)
+	fs.Symlink("../libs/lib2", "src/vendor-lib")
+
+	changes, changed, err := d.Scan(ctx)
+	require.Nil(t, err)
+	require.True(t, changed)
+	require.Equal(t, daemon.ChangeSet{
+		{Path: "src/config/..2021_03_01/app.go", Name: "app.go", Op: daemon.OpRemoved},
+		{Path: "src/config/..2021_03_02/app.go", Name: "app.go", Op: daemon.OpCreated},
+		{Path: "src/config/app.go", Name: "app.go", Op: daemon.OpModified},
+		{Path: "src/vendor-lib/lib.go", Name: "lib.go", Op: daemon.OpModified},
+	}, changes)
+}
+
+func TestDaemon_FollowSymlinks_OSFileSystem(t *testing.T) {
+	t.Parallel()
+
+	// the temporary directory may be behind a symlink itself
+	tmp, err := filepath.EvalSymlinks(t.TempDir())
+	require.Nil(t, err)
+	base := filepath.Join(tmp, "src")
+	for _, dir := range []string{filepath.Join(base, "sub"), filepath.Join(tmp, "lib")} {
+		require.Nil(t, os.MkdirAll(dir, 0755))
+	}
+	require.Nil(t, ioutil.WriteFile(filepath.Join(base, "main.go"), []byte("package main\n"), 0644))
+	require.Nil(t, ioutil.WriteFile(filepath.Join(tmp, "lib", "lib.go"), []byte("package lib\n"), 0644))
+	require.Nil(t, os.Symlink("../lib", filepath.Join(base, "lib")))
+	require.Nil(t, os.Symlink("..", filepath.Join(base, "sub", "loop")))
+
+	d, err := daemon.NewWithEnvironment(map[string]string{},
+		daemon.WithStateDir(""),
+		daemon.WithBasePath(base),
+		daemon.WithFollowSymlinks(true),
+	)
+	require.Nil(t, err, "daemon creation failure")
+	require.Equal(t, map[string]string{
+		filepath.Join(base, "lib", "lib.go"): filepath.Join(tmp, "lib", "lib.go"),
+		filepath.Join(base, "main.go"):       "",
+	}, collectedFiles(t, d))
+}
@@ -1,10 +1,9 @@
 package daemon
 
 import (
+	"bytes"
 	"context"
 	"os"
-	"os/exec"
-	"path/filepath"
 	"regexp"
 	"strings"
 	"sync"
@@ -13,47 +12,93 @@ import (
 	"github.com/pkg/errors"
 )
 
-// FileInfo captures file path, name and modification time.
+// FileInfo captures file path, name, size and modification time.
 // This information is required for the watch functionality.
 type FileInfo struct {
 	Path    string
 	Name    string
 	ModTime time.Time
+	Size    int64
+	// Target is the real path of a file reached through symlinks,
+	// when they are followed
+	Target string
 }
 
-// CollectFiles checks if any watched file has changed
+// CollectFiles collects the watched files of the base path and the other
+// roots. A file in several roots is collected once, from the first of them.
 func (d *Daemon) CollectFiles(ctx context.Context) ([]FileInfo, error) {
 	var files []FileInfo
+	seen := map[string]bool{}
 
-	err := filepath.Walk(d.BasePath, func(path string, info os.FileInfo, err error) error {
-		if info.IsDir() ||
-			strings.HasPrefix(path, ".git") ||
-			(!info.IsDir() && filepath.Ext(path) != d.Extention) {
-			return err // this will be nil if there is no problem with the file
+	for i, r := range d.watchedRoots() {
+		rootFiles, err := d.collectRoot(ctx, r, i == 0)
+		if err != nil {
+			return nil, err
 		}
-
-		if len(d.excluded) != 0 {
-			isExcl, err := d.IsExcluded(ctx, path, info.Name())
-			if err != nil {
-				d.logger.Panic(errors.Wrap(err, "cannot proccess exclusion of files"))
-			}
-			if isExcl {
-				return nil
+		for _, f := range rootFiles {
+			key := absPath(f.Path)
+			if seen[key] {
+				continue
 			}
+			seen[key] = true
+			files = append(files, f)
 		}
+	}
+	return files, nil
+}
 
-		files = append(files, FileInfo{
-			Path:    path,
-			Name:    info.Name(),
-			ModTime: info.ModTime(),
-		})
-		return nil
-	})
+// nextScanID provides ID for a new scan.
+func (d *Daemon) nextScanID() int {
+	d.stateMux.Lock()
+	defer d.stateMux.Unlock()
 
+	d.lastScanID++
+	return d.lastScanID
+}
+
+// Scan collects the watched files and detects changes since the previous
+// scan, reporting whether the command should run.
+func (d *Daemon) Scan(ctx context.Context) (ChangeSet, bool, error) {
+	start := d.clock.Now()
+	ctx = d.withScanID(ctx, d.nextScanID())
+	d.publish(Event{Type: EventScanStarted})
+
+	files, err := d.collectFiles(ctx)
 	if err != nil {
-		return nil, errors.Wrapf(err, "error collecting files from %s", d.BasePath)
+		return nil, false, err
 	}
-	return files, nil
+	if d.SemanticFilter {
+		d.cacheGoCode(ctx, files)
+	}
+
+	changes, changed := d.DetectChanges(ctx, files)
+	if changed && d.SemanticFilter && len(changes) != 0 {
+		changes = d.FilterSemanticChanges(ctx, changes)
+		changed = len(changes) != 0
+		// the snapshot is persisted with the code of the changed files
+		if err := d.SaveSnapshot(); err != nil {
+			d.log(ctx).Warnf("cannot persist snapshot: %s", err)
+		}
+	}
+
+	duration := d.clock.Since(start)
+	d.stateMux.Lock()
+	d.lastScan = start
+	d.lastScanDuration = duration
+	d.stateMux.Unlock()
+
+	d.metrics.scans.Inc()
+	d.metrics.scanDuration.Observe(duration.Seconds())
+	d.metrics.filesWatched.Set(float64(len(files)))
+	for _, c := range changes {
+		c := c
+		d.log(ctx).Infof("File %s has been %s", c.Path, c.Op)
+		d.metrics.changes.Inc(string(c.Op))
+		d.publish(Event{Type: EventFileChanged, Change: &c})
+	}
+	d.publish(Event{Type: EventScanFinished, Files: len(files), Duration: duration})
+
+	return changes, changed, nil
 }
 
 // ProcessFilesInParallel checks files in parallel.
@@ -77,9 +122,9 @@ LOOP:
 		wg.Add(1)
 		go func(wg *sync.WaitGroup, f FileInfo, doneCh chan struct{}, stopCh chan struct{}) {
 			defer wg.Done()
-			time.Sleep(100 * time.Millisecond)
+			d.clock.Sleep(100 * time.Millisecond)
 
-			lastChecked := time.Now().Add(-d.frequency)
+			lastChecked := d.clock.Now().Add(-d.frequency)
 			if f.ModTime.After(lastChecked) {
 				d.logger.Infof("File %s has changed", f.Name)
 				stopCh <- struct{}{}
@@ -103,9 +148,15 @@ LOOP:
 
 // IsExcluded filters files based on custom exclusion configuration
 func (d *Daemon) IsExcluded(ctx context.Context, path, name string) (bool, error) {
+	return isExcluded(d.excluded, path, name)
+}
+
+// isExcluded reports whether the file matches any of the exclusion patterns,
+// see Excluded.
+func isExcluded(excluded []string, path, name string) (bool, error) {
 	toExclude := false
 
-	for _, ex := range d.excluded {
+	for _, ex := range excluded {
 		if ex == "" {
 			continue
 		}
@@ -130,30 +181,94 @@ func (d *Daemon) IsExcluded(ctx context.Context, path, name string) (bool, error
 	return toExclude, nil
 }
 
-func (d *Daemon) runOutcomeChecker(cmdParts []string, sigCh chan os.Signal, doneCh, cancelCh chan struct{}) {
+// validateExcluded checks that the regex exclusion patterns compile,
+// see Excluded.
+func validateExcluded(excluded []string) error {
+	for _, ex := range excluded {
+		if strings.ContainsAny(ex, "*?{}[]()+") {
+			if _, err := regexp.Compile(ex); err != nil {
+				return errors.Wrapf(err, "invalid exclusion pattern %q", ex)
+			}
+		}
+	}
+	return nil
+}
+
+// shutdown persists the state and stops the service, once the run
+// in progress, which may start the service, finished.
+func (d *Daemon) shutdown() {
+	if err := d.SaveSnapshot(); err != nil {
+		d.logger.Warnf("cannot persist snapshot: %s", err)
+	}
+	d.cmdMux.Lock()
+	defer d.cmdMux.Unlock()
+	d.StopService()
+}
+
+func (d *Daemon) runOutcomeChecker(ctx context.Context, sigCh chan os.Signal,
+	doneCh chan ChangeSet, cancelCh chan struct{}) {
 	go func() {
 		for {
 			select {
+			case <-ctx.Done():
+				return
 			case <-sigCh:
-				d.logger.Info("You interrupted me 👹!")
+				d.logger.Infof("You interrupted me 👹!")
+				d.shutdown()
 				os.Exit(0)
-			case <-doneCh:
+			case changes := <-doneCh:
 				d.cmdMux.Lock()
 
-				cmd := exec.Command(cmdParts[0], cmdParts[1:]...)
-				// these can be commented out if not needed
-				cmd.Stdout = os.Stdout
-				cmd.Stderr = os.Stderr
-				err := cmd.Run()
-				if err != nil {
-					d.logger.Errorf("%s", errors.Wrap(err, "error occurred processing during file watch"))
-					cancelCh <- struct{}{}
+				run := d.Restart(ctx, changes)
+				log := d.runLogger(run.ID)
+				if !run.Succeeded() {
+					log.Errorf("%s", run.Error)
+					// the watch does not wait for the outcome once stopping
+					select {
+					case cancelCh <- struct{}{}:
+					case <-ctx.Done():
+					}
 					d.cmdMux.Unlock()
 					continue
 				}
-				d.logger.Info("command completed successfully")
+				log.Infof("command completed successfully")
+				d.LiveReload(changes)
 				d.cmdMux.Unlock()
 			}
 		}
 	}()
 }
+
+// commandData is provided to the command template.
+type commandData struct {
+	Files    string
+	Packages string
+}
+
+// BuildCommand renders the configured command for the detected changes
+// and splits it into the program and its arguments.
+func (d *Daemon) BuildCommand(ctx context.Context, changes ChangeSet) ([]string, error) {
+	data := commandData{
+		Files:    strings.Join(changes.Paths(), " "),
+		Packages: AllPackages,
+	}
+
+	if d.GoMode && len(changes) != 0 {
+		pkgs, err := d.AffectedPackages(ctx, changes)
+		if err != nil {
+			return nil, err
+		}
+		data.Packages = strings.Join(pkgs, " ")
+	}
+
+	var buf bytes.Buffer
+	if err := d.command.Execute(&buf, data); err != nil {
+		return nil, errors.Wrap(err, "cannot render the command")
+	}
+
+	cmdParts := strings.Fields(buf.String())
+	if len(cmdParts) == 0 {
+		return nil, errors.New("command is empty")
+	}
+	return cmdParts, nil
+}
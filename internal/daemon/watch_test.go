@@ -384,6 +384,11 @@ func TestDaemon_IsExcluded(t *testing.T) {
 			os.Setenv("WATCHER_DAEMON_FREQUENCY", tt.fields.Frequency)
 
 			d, err := daemon.New()
+			if tt.wantErr {
+				// invalid exclusions are rejected when creating the daemon
+				require.NotNil(t, err, "invalid exclusion accepted")
+				return
+			}
 			require.Nil(t, err, "daemon creation failure")
 
 			got, err := d.IsExcluded(ctx, tt.args.path, tt.args.name)
@@ -452,48 +457,57 @@ func TestDaemon_ProcessFilesInParallel(t *testing.T) {
 	for _, tt := range tests {
 		tt := tt
 		t.Run(tt.name, func(t *testing.T) {
-			os.Setenv("WATCHER_DAEMON_BASE_PATH", tt.fields.BasePath)
-			os.Setenv("WATCHER_DAEMON_EXTENSION", tt.fields.Extention)
-			os.Setenv("WATCHER_DAEMON_COMMAND", tt.fields.Command)
-			os.Setenv("WATCHER_DAEMON_EXCLUDED", tt.fields.Excluded)
-			os.Setenv("WATCHER_DAEMON_FREQUENCY", tt.fields.Frequency)
-
-			d, err := daemon.New()
+			clock := daemon.NewFakeClock(time.Unix(0, 0))
+			d, err := daemon.NewWithEnvironment(map[string]string{
+				"WATCHER_DAEMON_BASE_PATH": tt.fields.BasePath,
+				"WATCHER_DAEMON_EXTENSION": tt.fields.Extention,
+				"WATCHER_DAEMON_COMMAND":   tt.fields.Command,
+				"WATCHER_DAEMON_EXCLUDED":  tt.fields.Excluded,
+				"WATCHER_DAEMON_FREQUENCY": tt.fields.Frequency,
+			}, daemon.WithClock(clock))
 			require.Nil(t, err, "daemon creation failure")
 
-			// Within this gouroutine we verify
-			go func() {
-				fr, err := strconv.Atoi(tt.fields.Frequency)
-				require.Nil(t, err)
-				timeout := time.After(time.Duration(fr+1) * time.Second)
-				if tt.expectChange {
-					select {
-					case <-tt.args.doneCh: // receiving on this channel is expected if a change is detected
-					case <-timeout:
-						t.Error("TestDaemon_ProcessFilesInParallel - change should have been detected")
-					}
-				} else {
-					select {
-					case <-tt.args.doneCh: // receiving on this channel is not expected as no change happened
-						t.Error("TestDaemon_ProcessFilesInParallel - change was detected")
-					case <-timeout:
-					}
-				}
-			}()
-
 			files, err := d.CollectFiles(tt.args.ctx)
 			if err != nil {
 				t.Errorf("TestDaemon_ProcessFilesInParallel - %s", err)
 			}
 			require.NotEmpty(t, files)
+
+			fr, err := strconv.Atoi(tt.fields.Frequency)
+			require.Nil(t, err)
+			frequency := time.Duration(fr) * time.Second
+
+			// the first file is processed first, the processing stops
+			// with the first change
+			sleeps := 1
 			if tt.expectChange {
 				// simulate change
-				err := os.Chtimes(files[0].Path, time.Now(), time.Now())
-				if err != nil {
-					t.Errorf("TestDaemon_ProcessFilesInParallel - %s", err)
+				clock.Advance(files[0].ModTime.Add(time.Second).Sub(clock.Now()))
+			} else {
+				sleeps = len(files)
+				var newest time.Time
+				for _, f := range files {
+					if f.ModTime.After(newest) {
+						newest = f.ModTime
+					}
 				}
+				clock.Advance(newest.Add(frequency).Sub(clock.Now()))
 			}
+
+			go func() {
+				// each file is processed after a virtual delay
+				for i := 0; i < sleeps; i++ {
+					clock.BlockUntil(1)
+					clock.Advance(100 * time.Millisecond)
+				}
+			}()
 			d.ProcessFilesInParallel(tt.args.ctx, files, tt.args.doneCh)
+
+			if tt.expectChange {
+				require.Len(t, tt.args.doneCh, 1, "change should have been detected")
+			} else {
+				require.Len(t, tt.args.doneCh, 0, "change was detected")
+			}
 		})
 	}
 }
@@ -0,0 +1,182 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Webhook payload formats.
+const (
+	WebhookJSON  = "json"
+	WebhookSlack = "slack"
+)
+
+// Outcomes of a run the webhooks are fired on.
+const (
+	WebhookOnFailure  = "failure"
+	WebhookOnRecovery = "recovery"
+	WebhookOnSuccess  = "success"
+	WebhookOnAlways   = "always"
+)
+
+// webhookBackoff is the delay before the first retry of a failed webhook,
+// doubled for each further retry.
+const webhookBackoff = 500 * time.Millisecond
+
+// WebhookPayload is the body of a generic JSON webhook.
+type WebhookPayload struct {
+	Outcome string `json:"outcome"` // failure, recovery or success
+	Text    string `json:"text"`
+	Run     Run    `json:"run"`
+}
+
+// slackPayload is the body of a Slack-compatible webhook.
+type slackPayload struct {
+	Text string `json:"text"`
+}
+
+func validateWebhooks(format string, on []string) error {
+	switch format {
+	case WebhookJSON, WebhookSlack:
+	default:
+		return errors.Errorf("unknown webhook format %q", format)
+	}
+	for _, o := range on {
+		switch o {
+		case WebhookOnFailure, WebhookOnRecovery, WebhookOnSuccess, WebhookOnAlways:
+		default:
+			return errors.Errorf("unknown webhook outcome %q", o)
+		}
+	}
+	return nil
+}
+
+// runOutcome classifies the run, a success after a failed run is a recovery.
+func runOutcome(run Run, previous *Run) string {
+	switch {
+	case !run.Succeeded():
+		return WebhookOnFailure
+	case previous != nil && !previous.Succeeded():
+		return WebhookOnRecovery
+	default:
+		return WebhookOnSuccess
+	}
+}
+
+// notifyWebhooks fires the webhooks configured for the outcome of the run
+// in the background.
+func (d *Daemon) notifyWebhooks(run Run, previous *Run) {
+	if len(d.webhooks) == 0 {
+		return
+	}
+
+	outcome := runOutcome(run, previous)
+	fire := false
+	for _, o := range d.webhookOn {
+		if o == outcome || o == WebhookOnAlways {
+			fire = true
+		}
+	}
+	if !fire {
+		return
+	}
+
+	text := webhookText(run, outcome)
+	var payload interface{} = WebhookPayload{Outcome: outcome, Text: text, Run: run}
+	if d.WebhookFormat == WebhookSlack {
+		payload = slackPayload{Text: text}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		d.runLogger(run.ID).Warnf("cannot encode the webhook payload: %s", err)
+		return
+	}
+
+	for _, url := range d.webhooks {
+		go func(url string) {
+			if err := d.sendWebhook(context.Background(), url, body); err != nil {
+				d.runLogger(run.ID).Warnf("webhook failed: %s", err)
+			}
+		}(url)
+	}
+}
+
+func webhookText(run Run, outcome string) string {
+	var b strings.Builder
+	switch outcome {
+	case WebhookOnFailure:
+		fmt.Fprintf(&b, "watcher-daemon: run %d failed", run.ID)
+		if run.ExitCode > 0 {
+			fmt.Fprintf(&b, " (exit %d)", run.ExitCode)
+		}
+	case WebhookOnRecovery:
+		fmt.Fprintf(&b, "watcher-daemon: run %d succeeded, recovered from the previous failure", run.ID)
+	default:
+		fmt.Fprintf(&b, "watcher-daemon: run %d succeeded", run.ID)
+	}
+	if run.Command != "" {
+		fmt.Fprintf(&b, ": %s", run.Command)
+	}
+	if run.Error != "" {
+		fmt.Fprintf(&b, "\n%s", run.Error)
+	}
+	return b.String()
+}
+
+// sendWebhook posts the body to the URL, retrying with an exponential
+// backoff on network errors and 5xx or 429 responses.
+func (d *Daemon) sendWebhook(ctx context.Context, url string, body []byte) error {
+	timeout := time.Duration(d.WebhookTimeout) * time.Second
+	backoff := webhookBackoff
+
+	var err error
+	for attempt := 0; attempt <= d.WebhookRetries; attempt++ {
+		if attempt != 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-d.clock.After(backoff):
+			}
+			backoff *= 2
+		}
+
+		var retry bool
+		retry, err = postWebhook(ctx, url, body, timeout)
+		if err == nil || !retry {
+			return err
+		}
+	}
+	return errors.Wrapf(err, "giving up after %d retries", d.WebhookRetries)
+}
+
+// postWebhook posts the body once and reports whether a failure is worth
+// retrying.
+func postWebhook(ctx context.Context, url string, body []byte, timeout time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, errors.Wrapf(err, "invalid webhook %s", url)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return true, errors.Wrapf(err, "cannot post to %s", url)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return false, nil
+	}
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+	return retry, errors.Errorf("POST %s returned %s", url, resp.Status)
+}
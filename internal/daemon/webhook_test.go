@@ -0,0 +1,120 @@
+// +build unit_tests
+
+package daemon_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tamarakaufler/watcher-daemon/internal/daemon"
+)
+
+// webhookReceiver is a stand-in for the webhook endpoint, failing
+// the first failures requests.
+func webhookReceiver(t *testing.T, failures int32) (*httptest.Server, <-chan []byte, *int32) {
+	t.Helper()
+
+	bodies := make(chan []byte, 10)
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		if atomic.AddInt32(&requests, 1) <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		require.Nil(t, err)
+		bodies <- body
+	}))
+	return srv, bodies, &requests
+}
+
+func newWebhookDaemon(t *testing.T, command string, env map[string]string) *daemon.Daemon {
+	t.Helper()
+
+	environment := map[string]string{
+		"WATCHER_DAEMON_BASE_PATH": "fixtures/basepath",
+		"WATCHER_DAEMON_EXTENSION": ".go",
+		"WATCHER_DAEMON_COMMAND":   command,
+		"WATCHER_DAEMON_STATE_DIR": t.TempDir(),
+	}
+	for k, v := range env {
+		environment[k] = v
+	}
+
+	d, err := daemon.NewWithEnvironment(environment)
+	require.Nil(t, err, "daemon creation failure")
+	return d
+}
+
+func receive(t *testing.T, bodies <-chan []byte) []byte {
+	t.Helper()
+
+	select {
+	case body := <-bodies:
+		return body
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not received")
+	}
+	return nil
+}
+
+func TestDaemon_Webhooks_Failure(t *testing.T) {
+	srv, bodies, requests := webhookReceiver(t, 2)
+	defer srv.Close()
+
+	d := newWebhookDaemon(t, "false", map[string]string{
+		"WATCHER_DAEMON_WEBHOOKS":        srv.URL,
+		"WATCHER_DAEMON_WEBHOOK_RETRIES": "2",
+	})
+	d.RunCommand(context.Background(), nil)
+
+	var payload daemon.WebhookPayload
+	require.Nil(t, json.Unmarshal(receive(t, bodies), &payload))
+	require.Equal(t, "failure", payload.Outcome)
+	require.Equal(t, 1, payload.Run.ID)
+	require.Equal(t, 1, payload.Run.ExitCode)
+	require.Contains(t, payload.Text, "run 1 failed (exit 1): false")
+	require.Equal(t, int32(3), atomic.LoadInt32(requests), "the webhook must be retried")
+}
+
+func TestDaemon_Webhooks_Recovery(t *testing.T) {
+	srv, bodies, _ := webhookReceiver(t, 0)
+	defer srv.Close()
+
+	// the command fails until the file exists
+	file := filepath.Join(t.TempDir(), "built")
+	d := newWebhookDaemon(t, "ls "+file, map[string]string{
+		"WATCHER_DAEMON_WEBHOOKS":       srv.URL,
+		"WATCHER_DAEMON_WEBHOOK_FORMAT": "slack",
+		"WATCHER_DAEMON_WEBHOOK_ON":     "recovery",
+	})
+
+	ctx := context.Background()
+	d.RunCommand(ctx, nil)
+	require.Nil(t, ioutil.WriteFile(file, nil, 0600))
+	d.RunCommand(ctx, nil)
+	d.RunCommand(ctx, nil)
+
+	var payload map[string]interface{}
+	require.Nil(t, json.Unmarshal(receive(t, bodies), &payload))
+	require.Equal(t, map[string]interface{}{
+		"text": "watcher-daemon: run 2 succeeded, recovered from the previous failure: ls " + file,
+	}, payload)
+
+	select {
+	case body := <-bodies:
+		t.Fatalf("only the recovery must be notified, got %s", body)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
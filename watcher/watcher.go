@@ -0,0 +1,280 @@
+// Package watcher watches a tree of files for changes, checking it at regular
+// intervals, and handles each set of changes with a callback or a command.
+//
+//	w, err := watcher.New(
+//		watcher.WithBasePath("."),
+//		watcher.WithInclude("*.go", "*.tmpl"),
+//		watcher.WithExclude("vendor"),
+//		watcher.WithInterval(time.Second),
+//		watcher.WithHandler(func(ctx context.Context, changes watcher.ChangeSet) error {
+//			log.Printf("changed: %v", changes.Paths())
+//			return nil
+//		}),
+//	)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	w.Run(ctx)
+package watcher
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/tamarakaufler/watcher-daemon/internal/daemon"
+)
+
+type (
+	// Change is a change of one watched file.
+	Change = daemon.Change
+	// ChangeSet is the set of changes detected by one check.
+	ChangeSet = daemon.ChangeSet
+	// Op is the kind of a change.
+	Op = daemon.Op
+	// FileInfo describes a watched file.
+	FileInfo = daemon.FileInfo
+	// Run is the outcome of handling a change set.
+	Run = daemon.Run
+	// Backend provides the files of the watched tree for each check.
+	Backend = daemon.Backend
+	// Root is a directory or a single file watched in addition to the base
+	// path, with its own include and exclude patterns.
+	Root = daemon.Root
+	// Handler handles a change set, an error fails the run.
+	Handler = daemon.Handler
+	// FileSystem is the tree the base path is walked and read in.
+	FileSystem = daemon.FileSystem
+	// OSFileSystem is the file system of the operating system.
+	OSFileSystem = daemon.OSFileSystem
+	// MemFileSystem is an in-memory file system.
+	MemFileSystem = daemon.MemFileSystem
+	// Clock provides the time to the watcher.
+	Clock = daemon.Clock
+	// Ticker delivers ticks of a Clock at intervals.
+	Ticker = daemon.Ticker
+	// FakeClock is a Clock, whose time only moves when advanced.
+	FakeClock = daemon.FakeClock
+	// Logger is the logging interface of the watcher.
+	Logger = daemon.Logger
+	// Option configures the watcher.
+	Option = daemon.Option
+	// ChangeBatch is a change set delivered to the subscribers.
+	ChangeBatch = daemon.ChangeBatch
+	// Policy decides what happens to a change batch when the buffer
+	// of a subscriber is full.
+	Policy = daemon.Policy
+	// Event describes something that happened in the watcher.
+	Event = daemon.Event
+	// EventType identifies what happened in the watcher.
+	EventType = daemon.EventType
+)
+
+// Kinds of changes.
+const (
+	OpCreated  = daemon.OpCreated
+	OpModified = daemon.OpModified
+	OpRemoved  = daemon.OpRemoved
+)
+
+// Types of events.
+const (
+	EventScanStarted  = daemon.EventScanStarted
+	EventScanFinished = daemon.EventScanFinished
+	EventFileChanged  = daemon.EventFileChanged
+	EventRunStarted   = daemon.EventRunStarted
+	EventRunOutput    = daemon.EventRunOutput
+	EventRunFinished  = daemon.EventRunFinished
+)
+
+// Policies of the subscriptions.
+const (
+	// PolicyDrop drops the batch for a subscriber, whose buffer is full.
+	PolicyDrop = daemon.PolicyDrop
+	// PolicyBlock pauses the watch until the subscriber receives the batch.
+	PolicyBlock = daemon.PolicyBlock
+)
+
+// Watcher watches a tree of files and handles the changes.
+type Watcher struct {
+	d *daemon.Daemon
+}
+
+// New creates a watcher configured by the options. Without options, it
+// checks .go files under the current directory every 5 seconds and
+// keeps no state on disk.
+func New(opts ...Option) (*Watcher, error) {
+	opts = append([]Option{daemon.WithStateDir("")}, opts...)
+	d, err := daemon.NewWithEnvironment(map[string]string{}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Watcher{d: d}, nil
+}
+
+// NewFromEnv creates a watcher configured by the WATCHER_DAEMON_* environment
+// variables, as the watcher-daemon command is, and by the options.
+func NewFromEnv(opts ...Option) (*Watcher, error) {
+	d, err := daemon.New(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Watcher{d: d}, nil
+}
+
+// Run watches the files until the context is done and returns its error.
+func (w *Watcher) Run(ctx context.Context) error {
+	w.d.Watch(ctx, nil)
+	return ctx.Err()
+}
+
+// Trigger handles an empty change set without waiting for a change.
+func (w *Watcher) Trigger() {
+	w.d.Trigger()
+}
+
+// Subscribe provides a channel receiving the change sets handled by the watcher,
+// until the context is done, when the channel is closed.
+func (w *Watcher) Subscribe(ctx context.Context) <-chan ChangeBatch {
+	return w.d.Subscribe(ctx)
+}
+
+// Events provides a channel receiving the scan, change and run events and
+// a function unsubscribing from them. Events are dropped while the buffer
+// of the channel is full.
+func (w *Watcher) Events() (<-chan Event, func()) {
+	return w.d.Events()
+}
+
+// NextCheck returns the time the files are checked next, zero while they
+// are being checked or the watcher is not running.
+func (w *Watcher) NextCheck() time.Time {
+	return w.d.NextCheck()
+}
+
+// Runs returns the recent runs, the most recent run last.
+func (w *Watcher) Runs() []Run {
+	return w.d.Runs()
+}
+
+// WithBasePath sets the directory, whose files are watched.
+func WithBasePath(path string) Option {
+	return daemon.WithBasePath(path)
+}
+
+// WithInclude watches only the files, whose names match any of the patterns,
+// eg *.go, instead of the .go files.
+func WithInclude(patterns ...string) Option {
+	return daemon.WithInclude(patterns...)
+}
+
+// WithExclude excludes the files, whose path or name contains any of
+// the patterns, or matches it as a regular expression.
+func WithExclude(patterns ...string) Option {
+	return daemon.WithExclude(patterns...)
+}
+
+// WithInterval sets how often the files are checked for changes.
+func WithInterval(interval time.Duration) Option {
+	return daemon.WithInterval(interval)
+}
+
+// WithRoots watches the directories or single files in addition to the base
+// path. A file in several roots is reported once.
+func WithRoots(roots ...Root) Option {
+	return daemon.WithRoots(roots...)
+}
+
+// WithFollowSymlinks makes the watcher watch the files behind symlinks.
+// Each directory is walked once, so symlink cycles end, and a symlink
+// pointing to another target is a change of the files behind it.
+func WithFollowSymlinks(follow bool) Option {
+	return daemon.WithFollowSymlinks(follow)
+}
+
+// WithIdleInterval makes the watcher poll adaptively: the interval doubles
+// after each check without changes, up to the idle interval, and drops back
+// to the interval set by WithInterval when a change is found.
+func WithIdleInterval(interval time.Duration) Option {
+	return daemon.WithIdleInterval(interval)
+}
+
+// WithJitter randomly varies each interval by up to the fraction of it,
+// eg 0.1 for ±10%.
+func WithJitter(fraction float64) Option {
+	return daemon.WithJitter(fraction)
+}
+
+// WithBackend makes the watcher get the files from the backend instead
+// of walking the base path. The files are filtered by the include and
+// exclude patterns.
+func WithBackend(b Backend) Option {
+	return daemon.WithBackend(b)
+}
+
+// NewMemFileSystem creates an empty in-memory file system.
+func NewMemFileSystem() *MemFileSystem {
+	return daemon.NewMemFileSystem()
+}
+
+// WithFileSystem makes the watcher walk and read the base path in the file
+// system, eg an in-memory or overlay tree, instead of the disk.
+func WithFileSystem(fs FileSystem) Option {
+	return daemon.WithFileSystem(fs)
+}
+
+// NewFakeClock creates a fake clock set to the time.
+func NewFakeClock(now time.Time) *FakeClock {
+	return daemon.NewFakeClock(now)
+}
+
+// WithClock makes the watcher take the time from the clock, eg a FakeClock
+// advanced by a test, instead of the system clock.
+func WithClock(c Clock) Option {
+	return daemon.WithClock(c)
+}
+
+// WithHandler makes the watcher handle the changes with the handler
+// instead of running a command.
+func WithHandler(h Handler) Option {
+	return daemon.WithHandler(h)
+}
+
+// WithCommand makes the watcher run the command for the changes. The command
+// is a text/template, eg go test {{.Packages}}.
+func WithCommand(command string) Option {
+	return daemon.WithCommand(command)
+}
+
+// WithStateDir keeps the state of the watcher, eg the run history and
+// the snapshot of the files, in the directory.
+func WithStateDir(dir string) Option {
+	return daemon.WithStateDir(dir)
+}
+
+// WithLogger makes the watcher log with the logger.
+func WithLogger(l Logger) Option {
+	return daemon.WithLogger(l)
+}
+
+// WithStdout makes the command and the hooks write their standard output to w.
+func WithStdout(w io.Writer) Option {
+	return daemon.WithStdout(w)
+}
+
+// WithStderr makes the command and the hooks write their standard error to w.
+func WithStderr(w io.Writer) Option {
+	return daemon.WithStderr(w)
+}
+
+// WithSubscriptionBuffer sets the number of change batches buffered for each
+// subscriber, 16 by default.
+func WithSubscriptionBuffer(size int) Option {
+	return daemon.WithSubscriptionBuffer(size)
+}
+
+// WithSubscriptionPolicy sets what happens to a change batch when the buffer
+// of a subscriber is full, PolicyDrop by default.
+func WithSubscriptionPolicy(p Policy) Option {
+	return daemon.WithSubscriptionPolicy(p)
+}
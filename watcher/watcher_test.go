@@ -0,0 +1,116 @@
+// +build unit_tests
+
+package watcher_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tamarakaufler/watcher-daemon/watcher"
+)
+
+// fakeBackend provides files set by the test and signals each check.
+type fakeBackend struct {
+	mux     sync.Mutex
+	files   []watcher.FileInfo
+	checked chan struct{}
+}
+
+func (b *fakeBackend) Files(ctx context.Context) ([]watcher.FileInfo, error) {
+	b.mux.Lock()
+	files := append([]watcher.FileInfo(nil), b.files...)
+	b.mux.Unlock()
+
+	select {
+	case b.checked <- struct{}{}:
+	default:
+	}
+	return files, nil
+}
+
+func (b *fakeBackend) set(files ...watcher.FileInfo) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.files = files
+}
+
+func TestWatcher_Run(t *testing.T) {
+	start := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	backend := &fakeBackend{checked: make(chan struct{}, 1)}
+	backend.set(
+		watcher.FileInfo{Path: "docs/a.txt", Name: "a.txt", ModTime: start, Size: 1},
+		watcher.FileInfo{Path: "main.go", Name: "main.go", ModTime: start, Size: 1},
+	)
+
+	handled := make(chan watcher.ChangeSet, 1)
+	w, err := watcher.New(
+		watcher.WithBackend(backend),
+		watcher.WithInclude("*.txt", "*.md"),
+		watcher.WithExclude("drafts"),
+		watcher.WithInterval(10*time.Millisecond),
+		watcher.WithHandler(func(ctx context.Context, changes watcher.ChangeSet) error {
+			handled <- changes
+			return errors.New("broken")
+		}),
+	)
+	require.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- w.Run(ctx)
+	}()
+
+	// the first check makes the baseline
+	<-backend.checked
+	backend.set(
+		watcher.FileInfo{Path: "docs/a.txt", Name: "a.txt", ModTime: start.Add(time.Second), Size: 2},
+		watcher.FileInfo{Path: "docs/b.md", Name: "b.md", ModTime: start, Size: 1},
+		watcher.FileInfo{Path: "drafts/c.md", Name: "c.md", ModTime: start, Size: 1},
+		watcher.FileInfo{Path: "main.go", Name: "main.go", ModTime: start.Add(time.Second), Size: 2},
+	)
+
+	select {
+	case changes := <-handled:
+		require.Equal(t, watcher.ChangeSet{
+			{Path: "docs/a.txt", Name: "a.txt", Op: watcher.OpModified},
+			{Path: "docs/b.md", Name: "b.md", Op: watcher.OpCreated},
+		}, changes)
+	case <-time.After(5 * time.Second):
+		t.Fatal("changes not handled")
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		require.Equal(t, context.Canceled, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("watcher not stopped")
+	}
+
+	runs := w.Runs()
+	require.Len(t, runs, 1)
+	require.Equal(t, []string{"docs/a.txt", "docs/b.md"}, runs[0].Files)
+	require.Equal(t, "error occurred handling the changes: broken", runs[0].Error)
+}
+
+func TestNew_InvalidInterval(t *testing.T) {
+	t.Parallel()
+
+	_, err := watcher.New(watcher.WithInterval(-time.Second))
+	require.EqualError(t, err, "invalid frequency -1s")
+}
+
+func TestNew_InvalidPatterns(t *testing.T) {
+	t.Parallel()
+
+	_, err := watcher.New(watcher.WithExclude("foo("))
+	require.EqualError(t, err, "invalid exclusion pattern \"foo(\": error parsing regexp: missing closing ): `foo(`")
+
+	_, err = watcher.New(watcher.WithInclude("[*.go"))
+	require.EqualError(t, err, "invalid include pattern \"[*.go\": syntax error in pattern")
+}
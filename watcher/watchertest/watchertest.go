@@ -0,0 +1,387 @@
+// Package watchertest drives a watcher end-to-end in tests. A Harness creates
+// the watched tree, runs the watcher on a fake clock and provides helpers
+// to change the files, check them and wait for the runs:
+//
+//	h := watchertest.New(t, watcher.WithHandler(handle))
+//	h.WriteFile("main.go", "package main\n")
+//	h.Start()
+//
+//	h.WriteFile("main.go", "package main\n\nfunc main() {}\n")
+//	h.Tick()
+//	run := h.WaitForRun(1)
+//	h.AssertRunCount(1)
+//
+// The helpers are built on the public API of the watcher package.
+package watchertest
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tamarakaufler/watcher-daemon/watcher"
+)
+
+// Interval is how often, in fake time, the watcher of a Harness checks
+// the files, unless the options vary the interval.
+const Interval = time.Second
+
+// Start is the fake time a Harness starts at.
+var Start = time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+
+// Timeout is how long, in real time, the helpers wait for the watcher.
+var Timeout = 5 * time.Second
+
+// memDir is the root of the in-memory watched trees.
+const memDir = "/watched"
+
+// Harness runs a watcher on a tree of files for a test.
+type Harness struct {
+	// Dir is the root of the watched tree.
+	Dir string
+	// Clock is the fake clock of the watcher.
+	Clock *watcher.FakeClock
+	// Watcher is the watcher under test, set by Start.
+	Watcher *watcher.Watcher
+
+	t    testing.TB
+	opts []watcher.Option
+	// mem is the in-memory tree, nil for a tree on disk
+	mem *watcher.MemFileSystem
+
+	mux sync.Mutex
+	// scans counts the finished scans, changedScans the ones, which found
+	// changes, and changeBatches the change sets delivered for them
+	scans         int
+	changedScans  int
+	changeBatches int
+	changeSets    []watcher.ChangeSet
+	// lastStamp is the modification time of the latest written file
+	lastStamp time.Time
+	stopped   bool
+}
+
+// New creates a harness watching a temporary directory. The watcher is
+// configured by the options, which override the defaults of the harness,
+// and started by Start. Unless the options configure a handler or another
+// command, the default command of the watcher runs for the changes.
+func New(t testing.TB, opts ...watcher.Option) *Harness {
+	t.Helper()
+
+	return &Harness{
+		Dir:   t.TempDir(),
+		Clock: watcher.NewFakeClock(Start),
+		t:     t,
+		opts:  opts,
+	}
+}
+
+// NewInMemory creates a harness watching an in-memory tree.
+func NewInMemory(t testing.TB, opts ...watcher.Option) *Harness {
+	t.Helper()
+
+	return &Harness{
+		Dir:   memDir,
+		Clock: watcher.NewFakeClock(Start),
+		t:     t,
+		opts:  opts,
+		mem:   watcher.NewMemFileSystem(),
+	}
+}
+
+// Start starts the watcher and checks the files written so far, so that
+// the following changes are detected. The watcher is stopped when the test
+// finishes.
+func (h *Harness) Start() {
+	h.t.Helper()
+
+	opts := []watcher.Option{
+		watcher.WithBasePath(h.Dir),
+		watcher.WithClock(h.Clock),
+		watcher.WithInterval(Interval),
+		watcher.WithLogger(testLogger{h: h}),
+		watcher.WithStdout(testWriter{h: h}),
+		watcher.WithStderr(testWriter{h: h}),
+	}
+	if h.mem != nil {
+		opts = append(opts, watcher.WithFileSystem(h.mem))
+	}
+	w, err := watcher.New(append(opts, h.opts...)...)
+	if err != nil {
+		h.t.Fatalf("cannot create the watcher: %s", err)
+	}
+	h.Watcher = w
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events, unsubscribe := w.Events()
+	go h.recordEvents(events)
+	go h.recordChangeSets(w.Subscribe(ctx))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = w.Run(ctx)
+	}()
+
+	h.t.Cleanup(func() {
+		cancel()
+		<-done
+		unsubscribe()
+
+		h.mux.Lock()
+		h.stopped = true
+		h.mux.Unlock()
+	})
+
+	h.Tick()
+}
+
+func (h *Harness) recordEvents(events <-chan watcher.Event) {
+	changed := false
+	for ev := range events {
+		h.mux.Lock()
+		switch ev.Type {
+		case watcher.EventFileChanged:
+			changed = true
+		case watcher.EventScanFinished:
+			h.scans++
+			if changed {
+				h.changedScans++
+			}
+			changed = false
+		}
+		h.mux.Unlock()
+	}
+}
+
+func (h *Harness) recordChangeSets(batches <-chan watcher.ChangeBatch) {
+	for b := range batches {
+		h.mux.Lock()
+		h.changeSets = append(h.changeSets, b.Changes)
+		if len(b.Changes) != 0 {
+			h.changeBatches++
+		}
+		h.mux.Unlock()
+	}
+}
+
+// Tick advances the fake clock to the next check of the files and waits
+// until the watcher checked them and delivered the detected changes.
+// The watcher must not be paused.
+func (h *Harness) Tick() {
+	h.t.Helper()
+
+	h.mux.Lock()
+	scans := h.scans
+	h.mux.Unlock()
+
+	// the interval before the check may vary, eg with the idle interval
+	// or jitter, and other timers, eg of the debounce, may be pending
+	var next time.Time
+	h.waitFor("the watcher waiting for the next check", func() bool {
+		next = h.Watcher.NextCheck()
+		return !next.IsZero()
+	})
+
+	h.Clock.Advance(next.Sub(h.Clock.Now()))
+	h.waitFor("the check of the files", func() bool {
+		h.mux.Lock()
+		defer h.mux.Unlock()
+		return h.scans > scans && h.changeBatches >= h.changedScans
+	})
+}
+
+// Advance advances the fake clock by d without waiting for the watcher.
+func (h *Harness) Advance(d time.Duration) {
+	h.Clock.Advance(d)
+}
+
+// WaitForRun waits until the run with the ID finished and provides it.
+// The runs of a harness are numbered from 1.
+func (h *Harness) WaitForRun(id int) watcher.Run {
+	h.t.Helper()
+
+	var run watcher.Run
+	h.waitFor(fmt.Sprintf("run %d", id), func() bool {
+		for _, r := range h.Watcher.Runs() {
+			if r.ID == id {
+				run = r
+				return true
+			}
+		}
+		return false
+	})
+	return run
+}
+
+// AssertRunCount checks that n runs have finished.
+func (h *Harness) AssertRunCount(n int) {
+	h.t.Helper()
+
+	if got := len(h.Watcher.Runs()); got != n {
+		h.t.Errorf("%d runs finished, want %d", got, n)
+	}
+}
+
+// LastChangeSet provides the latest change set passed to the handler
+// or the command, nil if there is none.
+func (h *Harness) LastChangeSet() watcher.ChangeSet {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	if len(h.changeSets) == 0 {
+		return nil
+	}
+	return h.changeSets[len(h.changeSets)-1]
+}
+
+// waitFor waits until the condition holds.
+func (h *Harness) waitFor(what string, cond func() bool) {
+	h.t.Helper()
+
+	deadline := time.Now().Add(Timeout)
+	for {
+		if cond() {
+			return
+		}
+		if time.Now().After(deadline) {
+			h.t.Fatalf("timed out after %s waiting for %s", Timeout, what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Path provides the path of the file in the watched tree, as it appears
+// in the change sets.
+func (h *Harness) Path(name string) string {
+	return filepath.Join(h.Dir, filepath.FromSlash(name))
+}
+
+// WriteFile creates or replaces the file in the watched tree, creating
+// the parent directories. Each written file gets a later modification time
+// than the previous one, so that every write is detected.
+func (h *Harness) WriteFile(name, content string) {
+	h.t.Helper()
+
+	path := h.Path(name)
+	modTime := h.stamp()
+	if h.mem != nil {
+		h.mem.WriteFile(path, []byte(content), modTime)
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		h.t.Fatalf("cannot create the directory of %s: %s", name, err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		h.t.Fatalf("cannot write %s: %s", name, err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		h.t.Fatalf("cannot set the modification time of %s: %s", name, err)
+	}
+}
+
+// Remove removes the file from the watched tree.
+func (h *Harness) Remove(name string) {
+	h.t.Helper()
+
+	var err error
+	if h.mem != nil {
+		err = h.mem.Remove(h.Path(name))
+	} else {
+		err = os.Remove(h.Path(name))
+	}
+	if err != nil {
+		h.t.Fatalf("cannot remove %s: %s", name, err)
+	}
+}
+
+// Rename moves the file within the watched tree, keeping its modification
+// time.
+func (h *Harness) Rename(from, to string) {
+	h.t.Helper()
+
+	if h.mem == nil {
+		if err := os.MkdirAll(filepath.Dir(h.Path(to)), 0755); err != nil {
+			h.t.Fatalf("cannot create the directory of %s: %s", to, err)
+		}
+		if err := os.Rename(h.Path(from), h.Path(to)); err != nil {
+			h.t.Fatalf("cannot rename %s to %s: %s", from, to, err)
+		}
+		return
+	}
+
+	info, err := h.mem.Stat(h.Path(from))
+	if err != nil {
+		h.t.Fatalf("cannot rename %s to %s: %s", from, to, err)
+	}
+	content, err := h.mem.ReadFile(h.Path(from))
+	if err != nil {
+		h.t.Fatalf("cannot rename %s to %s: %s", from, to, err)
+	}
+	h.mem.WriteFile(h.Path(to), content, info.ModTime())
+	if err := h.mem.Remove(h.Path(from)); err != nil {
+		h.t.Fatalf("cannot rename %s to %s: %s", from, to, err)
+	}
+}
+
+// stamp provides the modification time of a written file, the fake time
+// unless a file was already written at the fake time.
+func (h *Harness) stamp() time.Time {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	t := h.Clock.Now()
+	if !t.After(h.lastStamp) {
+		t = h.lastStamp.Add(time.Second)
+	}
+	h.lastStamp = t
+	return t
+}
+
+// logf logs to the test until the watcher is stopped.
+func (h *Harness) logf(format string, args ...interface{}) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	if h.stopped {
+		return
+	}
+	h.t.Logf(format, args...)
+}
+
+// testLogger logs the watcher to the test.
+type testLogger struct {
+	h      *Harness
+	fields string
+}
+
+func (l testLogger) Debugf(format string, args ...interface{}) { l.logf("DEBUG", format, args...) }
+func (l testLogger) Infof(format string, args ...interface{})  { l.logf("INFO", format, args...) }
+func (l testLogger) Warnf(format string, args ...interface{})  { l.logf("WARN", format, args...) }
+func (l testLogger) Errorf(format string, args ...interface{}) { l.logf("ERROR", format, args...) }
+
+func (l testLogger) WithField(key string, value interface{}) watcher.Logger {
+	l.fields += fmt.Sprintf(" %s=%v", key, value)
+	return l
+}
+
+func (l testLogger) logf(level, format string, args ...interface{}) {
+	l.h.logf("%s %s%s", level, fmt.Sprintf(format, args...), l.fields)
+}
+
+// testWriter logs the output of the commands to the test.
+type testWriter struct {
+	h *Harness
+}
+
+func (w testWriter) Write(p []byte) (int, error) {
+	w.h.logf("%s", strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
@@ -0,0 +1,98 @@
+// +build unit_tests
+
+package watchertest_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tamarakaufler/watcher-daemon/watcher"
+	"github.com/tamarakaufler/watcher-daemon/watcher/watchertest"
+)
+
+func TestHarness(t *testing.T) {
+	tests := []struct {
+		name string
+		new  func(t testing.TB, opts ...watcher.Option) *watchertest.Harness
+	}{
+		{
+			name: "on disk",
+			new:  watchertest.New,
+		},
+		{
+			name: "in memory",
+			new:  watchertest.NewInMemory,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			h := tt.new(t, watcher.WithHandler(func(context.Context, watcher.ChangeSet) error {
+				return nil
+			}))
+			h.WriteFile("main.go", "package main\n")
+			h.WriteFile("docs/README.md", "# docs\n")
+			h.Start()
+
+			// nothing changed since the start, which checked the files once
+			h.Tick()
+			require.Nil(t, h.LastChangeSet())
+			h.AssertRunCount(0)
+
+			// the same content is detected as a change
+			h.WriteFile("main.go", "package main\n")
+			h.Tick()
+			require.Equal(t, watcher.ChangeSet{
+				{Path: h.Path("main.go"), Name: "main.go", Op: watcher.OpModified},
+			}, h.LastChangeSet())
+			run := h.WaitForRun(1)
+			require.Equal(t, []string{h.Path("main.go")}, run.Files)
+			require.Equal(t, watchertest.Start.Add(3*watchertest.Interval), run.Start)
+
+			h.Rename("main.go", "cmd/app/main.go")
+			h.Tick()
+			require.Equal(t, watcher.ChangeSet{
+				{Path: h.Path("cmd/app/main.go"), Name: "main.go", Op: watcher.OpCreated},
+				{Path: h.Path("main.go"), Name: "main.go", Op: watcher.OpRemoved},
+			}, h.LastChangeSet())
+			h.WaitForRun(2)
+
+			h.Remove("cmd/app/main.go")
+			h.Tick()
+			require.Equal(t, watcher.ChangeSet{
+				{Path: h.Path("cmd/app/main.go"), Name: "main.go", Op: watcher.OpRemoved},
+			}, h.LastChangeSet())
+			h.WaitForRun(3)
+			h.AssertRunCount(3)
+		})
+	}
+}
+
+func TestHarness_VaryingInterval(t *testing.T) {
+	t.Parallel()
+
+	h := watchertest.NewInMemory(t,
+		watcher.WithHandler(func(context.Context, watcher.ChangeSet) error { return nil }),
+		watcher.WithIdleInterval(8*watchertest.Interval),
+		watcher.WithJitter(0.5),
+	)
+	h.WriteFile("main.go", "package main\n")
+	h.Start()
+
+	// the interval grows while idle
+	for i := 0; i < 5; i++ {
+		h.Tick()
+	}
+	require.True(t, h.Clock.Now().Sub(watchertest.Start) > 6*watchertest.Interval)
+	h.AssertRunCount(0)
+
+	h.WriteFile("main.go", "package main\n\nfunc main() {}\n")
+	h.Tick()
+	require.Equal(t, watcher.ChangeSet{
+		{Path: h.Path("main.go"), Name: "main.go", Op: watcher.OpModified},
+	}, h.LastChangeSet())
+	h.WaitForRun(1)
+}